@@ -0,0 +1,201 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"github.com/image357/password/log"
+	"slices"
+	"strings"
+)
+
+// RecoveryDisabledErr is returned by BackfillRecovery and RotateRecoveryKey if recovery is not enabled.
+var RecoveryDisabledErr = errors.New("recovery disabled")
+
+// RecoveryRotationErr is wrapped by RotateRecoveryKey if a recovery entry cannot be rewritten.
+var RecoveryRotationErr = errors.New("cannot rotate recovery key")
+
+// RecoveryReport is the result of BackfillRecovery.
+type RecoveryReport struct {
+	// Backfilled holds the password ids that received a recovery entry.
+	Backfilled []string
+
+	// Missing holds the password ids that still lack a recovery entry, e.g. because their storage key was wrong.
+	Missing []string
+}
+
+// isPasswordId reports whether a normalized id is a password, i.e. neither a recovery entry, a version nor the keyring.
+func isPasswordId(id string) bool {
+	return !strings.HasSuffix(id, RecoveryIdSuffix) && !isHistoryId(id) && id != KeyringId
+}
+
+// MissingRecovery returns the sorted ids of all passwords without a recovery entry.
+func (m *Manager) MissingRecovery() ([]string, error) {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		exists[id] = true
+	}
+
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if isPasswordId(id) && !exists[id+RecoveryIdSuffix] {
+			missing = append(missing, id)
+		}
+	}
+	slices.Sort(missing)
+
+	return missing, nil
+}
+
+// BackfillRecovery writes recovery entries for all passwords that were stored before recovery was enabled.
+// storageKeyForIds returns the storage key of a password id. Passwords that cannot be decrypted with it are skipped
+// and reported as missing. Existing recovery entries are unchanged.
+func (m *Manager) BackfillRecovery(storageKeyForIds func(id string) string) (RecoveryReport, error) {
+	if !m.withRecovery {
+		return RecoveryReport{}, RecoveryDisabledErr
+	}
+
+	missing, err := m.MissingRecovery()
+	if err != nil {
+		return RecoveryReport{}, err
+	}
+
+	report := RecoveryReport{Backfilled: make([]string, 0), Missing: make([]string, 0)}
+	for _, id := range missing {
+		ok, err := m.backfillId(id, storageKeyForIds(id))
+		if err != nil {
+			return report, err
+		}
+		if ok {
+			report.Backfilled = append(report.Backfilled, id)
+		} else {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+
+	return report, nil
+}
+
+// backfillId writes the recovery entry of a normalized password id, if it is still missing and key decrypts the password.
+func (m *Manager) backfillId(id string, key string) (bool, error) {
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	recoveryId := id + RecoveryIdSuffix
+	_, err := m.storageBackend.Retrieve(recoveryId)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, NotFoundErr) {
+		return false, err
+	}
+
+	_, err = m.getEntry(id, key)
+	if err != nil {
+		log.Warn("cannot backfill recovery key file", "id", id, "error", err)
+		return false, nil
+	}
+
+	err = m.writeRecovery(recoveryId, key)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RotateRecoveryKey re-encrypts all recovery entries from oldRecoveryKey to newRecoveryKey
+// and uses newRecoveryKey for future recovery entries.
+// Every entry is re-encrypted and verified before anything is written. If any entry cannot be rewritten,
+// all written entries are restored and the previous recovery key is used again. Recovery entries that are encrypted to a public key
+// (see EnablePublicKeyRecovery) are skipped.
+func (m *Manager) RotateRecoveryKey(oldRecoveryKey string, newRecoveryKey string) error {
+	if !m.withRecovery {
+		return RecoveryDisabledErr
+	}
+	if m.hasRecoveryPublicKey() {
+		return fmt.Errorf("%w: public key recovery has no recovery key", RecoveryRotationErr)
+	}
+
+	// switch first, i.e. passwords that are created while RotateRecoveryKey runs use newRecoveryKey
+	keyBytes := []byte(newRecoveryKey)
+	previous := m.swapRecoveryKey(NewSecureBuffer(keyBytes), nil)
+	clear(keyBytes)
+
+	err := m.rotateRecoveryKey(oldRecoveryKey, newRecoveryKey)
+	if err != nil {
+		// swap back before destroying, i.e. concurrent writers never see a destroyed recovery key
+		m.swapRecoveryKey(previous, nil).Destroy()
+		return err
+	}
+
+	previous.Destroy()
+	return nil
+}
+
+// rotateRecoveryKey re-encrypts all recovery entries from oldRecoveryKey to newRecoveryKey.
+// Recovery entries that are already encrypted with newRecoveryKey are unchanged.
+func (m *Manager) rotateRecoveryKey(oldRecoveryKey string, newRecoveryKey string) error {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return err
+	}
+
+	// recovery entries are written while their password is locked, i.e. lock all possible owners in a fixed order
+	owners := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if owner, found := strings.CutSuffix(id, RecoveryIdSuffix); found {
+			owners = append(owners, owner)
+		} else if isPasswordId(id) {
+			owners = append(owners, id)
+		}
+	}
+	slices.Sort(owners)
+	owners = slices.Compact(owners)
+	for _, owner := range owners {
+		m.lockId(owner)
+		defer m.unlockId(owner)
+	}
+
+	// re-encrypt and verify all entries
+	records := make([]rewriteRecord, 0, len(owners))
+	for _, owner := range owners {
+		id := owner + RecoveryIdSuffix
+		oldData, err := m.storageBackend.Retrieve(id)
+		if errors.Is(err, NotFoundErr) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v: %w", RecoveryRotationErr, id, err)
+		}
+		if strings.HasPrefix(oldData, publicRecoveryPrefix) {
+			continue
+		}
+
+		newData, err := m.rewriteData(id, oldData, oldRecoveryKey, newRecoveryKey)
+		if errors.Is(err, AuthenticationErr) {
+			_, newErr := m.decrypt(id, oldData, newRecoveryKey)
+			if newErr == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v: %w", RecoveryRotationErr, id, err)
+		}
+		records = append(records, rewriteRecord{id, oldData, newData, true})
+	}
+
+	// commit
+	for i, r := range records {
+		err = m.storageBackend.Store(r.id, r.newData)
+		if err != nil {
+			m.rollbackRewrite(records[:i+1])
+			return fmt.Errorf("%w: %v: %w", RecoveryRotationErr, r.id, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package password
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestManager_BackfillRecovery(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	keys := map[string]string{"foo": "456", "bar": "789", "baz": "abc"}
+	for id, key := range keys {
+		err := m.Overwrite(id, "123", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := m.BackfillRecovery(func(id string) string { return keys[id] })
+	if !errors.Is(err, RecoveryDisabledErr) {
+		t.Errorf("BackfillRecovery() error = %v, want %v", err, RecoveryDisabledErr)
+	}
+
+	m.EnableRecovery("rec")
+	err = m.Overwrite("qux", "123", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := m.MissingRecovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"bar", "baz", "foo"}) {
+		t.Errorf("MissingRecovery() = %v", missing)
+	}
+
+	// wrong storage keys are reported
+	keys["baz"] = "wrong"
+	report, err := m.BackfillRecovery(func(id string) string { return keys[id] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Backfilled, []string{"bar", "foo"}) || !slices.Equal(report.Missing, []string{"baz"}) {
+		t.Errorf("BackfillRecovery() = %+v", report)
+	}
+
+	// tests
+	tests := []struct {
+		id      string
+		want    string
+		wantErr error
+	}{
+		{"foo", "456", nil},
+		{"bar", "789", nil},
+		{"qux", "def", nil},
+		{"baz", "", NotFoundErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := m.Get(tt.id+RecoveryIdSuffix, "rec")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	missing, err = m.MissingRecovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"baz"}) {
+		t.Errorf("MissingRecovery() = %v", missing)
+	}
+}
+
+func TestManager_RotateRecoveryKey(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	err := m.RotateRecoveryKey("rec", "new")
+	if !errors.Is(err, RecoveryDisabledErr) {
+		t.Errorf("RotateRecoveryKey() error = %v, want %v", err, RecoveryDisabledErr)
+	}
+
+	m.EnableRecovery("rec")
+	for _, id := range []string{"foo", "bar"} {
+		err = m.Overwrite(id, "123", id+"-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a wrong old key changes nothing
+	err = m.RotateRecoveryKey("wrong", "new")
+	if !errors.Is(err, RecoveryRotationErr) || !errors.Is(err, AuthenticationErr) {
+		t.Errorf("RotateRecoveryKey() error = %v, want %v", err, RecoveryRotationErr)
+	}
+	got, err := m.Get("foo"+RecoveryIdSuffix, "rec")
+	if err != nil || got != "foo-key" {
+		t.Errorf("Get() after failed rotation = %v, %v", got, err)
+	}
+	err = m.Overwrite("foo", "123", "foo-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.Get("foo"+RecoveryIdSuffix, "rec")
+	if err != nil || got != "foo-key" {
+		t.Errorf("Get() after failed rotation = %v, %v", got, err)
+	}
+
+	err = m.RotateRecoveryKey("rec", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("baz", "123", "baz-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"foo", "bar", "baz"} {
+		got, err := m.Get(id+RecoveryIdSuffix, "new")
+		if err != nil || got != id+"-key" {
+			t.Errorf("Get() of %v = %v, %v", id, got, err)
+		}
+		_, err = m.Get(id+RecoveryIdSuffix, "rec")
+		if err == nil {
+			t.Errorf("Get() of %v with the old recovery key succeeded", id)
+		}
+	}
+
+	// recovery entries that were written with the new key during a rotation are kept
+	m.EnableRecovery("newer")
+	err = m.Overwrite("qux", "123", "qux-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.EnableRecovery("new")
+	err = m.RotateRecoveryKey("new", "newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"foo", "bar", "baz", "qux"} {
+		got, err := m.Get(id+RecoveryIdSuffix, "newer")
+		if err != nil || got != id+"-key" {
+			t.Errorf("Get() of %v = %v, %v", id, got, err)
+		}
+	}
+
+	// public key recovery has no recovery key
+	publicKey, _, err := GenerateRecoveryKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.EnablePublicKeyRecovery(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RotateRecoveryKey("new", "newer")
+	if !errors.Is(err, RecoveryRotationErr) {
+		t.Errorf("RotateRecoveryKey() error = %v, want %v", err, RecoveryRotationErr)
+	}
+}
+
+func TestManager_RotateRecoveryKey_concurrent(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableRecovery("rec")
+	ids := []string{"foo", "bar", "baz"}
+	for _, id := range ids {
+		err := m.Overwrite(id, "123", id+"-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rotate while passwords are written, including failed rotations that restore the previous key
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				err := m.Overwrite(id, strconv.Itoa(i), id+"-key")
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			_ = m.RotateRecoveryKey("wrong", "failed")
+			_ = m.RotateRecoveryKey("rec", "new")
+			_ = m.RotateRecoveryKey("new", "rec")
+		}
+	}()
+	wg.Wait()
+
+	// no recovery entry was written with a destroyed recovery key
+	for _, id := range ids {
+		_, err := m.Get(id+RecoveryIdSuffix, "")
+		if err == nil {
+			t.Errorf("Get() of %v with an empty recovery key succeeded", id)
+		}
+		found := false
+		for _, key := range []string{"rec", "new", "failed"} {
+			got, err := m.Get(id+RecoveryIdSuffix, key)
+			found = found || (err == nil && got == id+"-key")
+		}
+		if !found {
+			t.Errorf("Get() of %v failed with all recovery keys", id)
+		}
+	}
+}
@@ -8,6 +8,7 @@ configure_file(
 install(
         FILES
         "${CMAKE_SOURCE_DIR}/LICENSE"
+        "${CMAKE_SOURCE_DIR}/NOTICE"
         "${CMAKE_BINARY_DIR}/DEPENDENCY_LICENSE"
         DESTINATION "${CMAKE_INSTALL_LIBDIR}/${CMAKE_PROJECT_NAME}-${CMAKE_PROJECT_VERSION}"
 )
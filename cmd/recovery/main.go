@@ -11,11 +11,20 @@ import (
 
 func main() {
 	args := os.Args[1:]
-	if len(args) != 2 {
+	if len(args) < 2 {
 		fmt.Println("Usage: recovery <file> <key>")
+		fmt.Println("       recovery <file> <share> <share> [<share> ...]")
+		fmt.Println("       recovery <file> --private-key <key file>")
+		fmt.Println("       recovery --generate-key <key file>")
 		os.Exit(1)
 	}
 
+	// generate a key pair for public key recovery
+	if args[0] == "--generate-key" {
+		generateKey(args[1])
+		return
+	}
+
 	// basic operation on args
 	abs, err := filepath.Abs(args[0])
 	if err != nil {
@@ -36,6 +45,28 @@ func main() {
 	abs = strings.ReplaceAll(abs, "\\", "/")
 	dir, file := path.Split(abs)
 	recoveryKey := args[1]
+	privateKey := ""
+
+	// read the private key of public key recovery
+	if args[1] == "--private-key" {
+		if len(args) != 3 {
+			fmt.Println("Error: missing <key file>")
+			os.Exit(1)
+		}
+		data, err := os.ReadFile(args[2])
+		if err != nil || strings.TrimSpace(string(data)) == "" {
+			fmt.Println("Error: cannot read <key file>")
+			os.Exit(1)
+		}
+		privateKey, recoveryKey = string(data), ""
+	} else if len(args) > 2 {
+		// combine shares of split recovery keys
+		recoveryKey, err = pwd.CombineRecoveryShares(args[1:])
+		if err != nil {
+			fmt.Printf("Error: cannot combine shares: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// reconstruct filenames
 	var recoveryFile string
@@ -72,7 +103,11 @@ func main() {
 		}
 
 		recoveryId := id + pwd.RecoveryIdSuffix
-		storageKey, err = pwd.Get(recoveryId, recoveryKey)
+		if privateKey != "" {
+			storageKey, err = pwd.RecoverStorageKey(recoveryId, privateKey)
+		} else {
+			storageKey, err = pwd.Get(recoveryId, recoveryKey)
+		}
 		if err != nil {
 			continue
 		}
@@ -94,7 +129,41 @@ func main() {
 	fmt.Printf("password file:    %v\n", passwordFile)
 	fmt.Printf("recovery file:    %v\n", recoveryFile)
 	fmt.Printf("storage key:      %v\n", storageKey)
-	fmt.Printf("recovery key:     %v\n", recoveryKey)
+	switch {
+	case privateKey != "":
+		fmt.Printf("private key file: %v\n", args[2])
+	case len(args) > 2:
+		// the combined recovery key is never revealed
+		fmt.Printf("recovery shares:  %v\n", len(args)-1)
+	default:
+		fmt.Printf("recovery key:     %v\n", recoveryKey)
+	}
 	fmt.Printf("id:               %v\n", id)
 	fmt.Printf("password:         %v\n", password)
 }
+
+// generateKey writes the private key of a new recovery key pair to file and prints the public key.
+func generateKey(file string) {
+	publicKey, privateKey, err := pwd.GenerateRecoveryKeyPair()
+	if err != nil {
+		fmt.Println("Error: cannot generate key pair")
+		os.Exit(1)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println("Error: cannot create <key file>")
+		os.Exit(1)
+	}
+	_, err = f.WriteString(privateKey + "\n")
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Println("Error: cannot write <key file>")
+		os.Exit(1)
+	}
+
+	fmt.Printf("private key file: %v\n", file)
+	fmt.Printf("public key:       %v\n", publicKey)
+}
@@ -9,8 +9,10 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -21,6 +23,9 @@ const entropyBlockLength = 24
 const paddingBlockLength = 16
 const timeFormat = "2006-01-02T15:04:05-07:00"
 
+// AuthenticationErr is wrapped if decryption fails, i.e. the storage key is wrong or the ciphertext was manipulated.
+var AuthenticationErr = errors.New("authentication failed")
+
 // HashFunc is a function signature.
 // The Hash function will be called for password and secret hashing.
 type HashFunc func(data []byte, salt []byte) [32]byte
@@ -46,39 +51,43 @@ func argon2iHash(data []byte, salt []byte) [32]byte {
 	return [32]byte(argon2.Key(data, salt, argon2iTime, argon2iMemory*1024, argon2iThreads, 32))
 }
 
-// getHashedPassword returns random salt + hash with base64 encoding.
-func getHashedPassword(password string) (string, error) {
-	salt := make([]byte, saltLength)
-	_, err := rand.Read(salt)
-	if err != nil {
-		return "", err
-	}
-
-	hash := Hash([]byte(password), salt)
-	hashedPassword := append(salt, hash[:]...)
-
-	return base64.StdEncoding.EncodeToString(hashedPassword), nil
+// getHashedPassword returns the argon2id hash of password in PHC string format.
+func getHashedPassword(password string, params Argon2Params) (string, error) {
+	return hashArgon2id(password, params)
 }
 
 // compareHashedPassword compares a hashed password A against a password B, by performing the hash operation on B with the same salt from A.
-// The input hashedPassword must be base64 encoded.
+// The input hashedPassword must be an argon2id hash in PHC string format, a foreign hash of ImportHash
+// or a legacy base64 encoded salt + hash of the Hash function.
 func compareHashedPassword(hashedPassword string, password string) (bool, error) {
+	return compareHashedPasswordWithHash(hashedPassword, password, Hash)
+}
+
+// compareHashedPasswordWithHash compares like compareHashedPassword, but uses hash for legacy hashed passwords.
+func compareHashedPasswordWithHash(hashedPassword string, password string, hash HashFunc) (bool, error) {
+	if strings.HasPrefix(hashedPassword, phcPrefix) {
+		return compareArgon2id(hashedPassword, password)
+	}
+	if isForeignHash(hashedPassword) {
+		return compareForeignHash(hashedPassword, password, true)
+	}
+
 	// decode hashed password
 	data1, err := base64.StdEncoding.DecodeString(hashedPassword)
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
 	}
 
 	// extract salt
 	if len(data1) < saltLength {
-		return false, fmt.Errorf("hashed password is too short")
+		return false, fmt.Errorf("%w: hashed password is too short", CorruptEntryErr)
 	}
 	salt := make([]byte, saltLength)
 	copy(salt, data1[:saltLength])
 
 	// hash other password
-	hash := Hash([]byte(password), salt)
-	data2 := append(salt, hash[:]...)
+	hashedOther := hash([]byte(password), salt)
+	data2 := append(salt, hashedOther[:]...)
 
 	// compare
 	result := subtle.ConstantTimeCompare(data1, data2) == 1
@@ -96,14 +105,26 @@ func comparePassword(password1 string, password2 string) bool {
 
 // packData encodes a given id and data string to json with entropy, padding and additional metadata.
 func packData(id string, data string) (string, error) {
-	if !utf8.ValidString(id) {
+	now := time.Now()
+	return packEntry(Entry{Id: id, Password: data, Created: now, Modified: now})
+}
+
+// packEntry encodes a given entry to json with entropy, padding and additional metadata.
+// The modification time is stored in the timestamp field. Empty labels and zero expiry times are omitted.
+func packEntry(entry Entry) (string, error) {
+	if !utf8.ValidString(entry.Id) {
 		return "", fmt.Errorf("invalid utf8 character in packData")
 	}
-	if !utf8.ValidString(data) {
+	if !utf8.ValidString(entry.Password) {
 		return "", fmt.Errorf("invalid utf8 character in packData")
 	}
+	for k, v := range entry.Labels {
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return "", fmt.Errorf("invalid utf8 character in packData")
+		}
+	}
 
-	paddingLength := paddingBlockLength - (len(data) % paddingBlockLength) + 1
+	paddingLength := paddingBlockLength - (len(entry.Password) % paddingBlockLength) + 1
 
 	entropy := make([]byte, entropyBlockLength)
 	_, err := rand.Read(entropy)
@@ -116,13 +137,22 @@ func packData(id string, data string) (string, error) {
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "")
 
-	err = enc.Encode(map[string]interface{}{
-		"id":        id,
-		"data":      data,
+	fields := map[string]interface{}{
+		"id":        entry.Id,
+		"data":      entry.Password,
 		"padding":   strings.Repeat(" ", paddingLength),
 		"entropy":   base64.StdEncoding.EncodeToString(entropy),
-		"timestamp": time.Now().Format(timeFormat),
-	})
+		"timestamp": entry.Modified.Format(timeFormat),
+		"created":   entry.Created.Format(timeFormat),
+	}
+	if len(entry.Labels) != 0 {
+		fields["labels"] = entry.Labels
+	}
+	if !entry.Expires.IsZero() {
+		fields["expires"] = entry.Expires.Format(timeFormat)
+	}
+
+	err = enc.Encode(fields)
 	if err != nil {
 		return "", err
 	}
@@ -132,8 +162,18 @@ func packData(id string, data string) (string, error) {
 
 // unpackData decodes a given json string to its id and data representation.
 func unpackData(input string) (string, string, error) {
+	entry, err := unpackEntry(input)
+	if err != nil {
+		return "", "", err
+	}
+	return entry.Id, entry.Password, nil
+}
+
+// unpackEntry decodes a given json string to its entry representation.
+// Legacy data without creation time reports the timestamp field as creation time.
+func unpackEntry(input string) (Entry, error) {
 	if !utf8.ValidString(input) {
-		return "", "", fmt.Errorf("invalid utf8 character in unpackData")
+		return Entry{}, fmt.Errorf("%w: invalid utf8 character in unpackData", CorruptEntryErr)
 	}
 
 	dec := json.NewDecoder(strings.NewReader(input))
@@ -142,42 +182,93 @@ func unpackData(input string) (string, string, error) {
 	temp := make(map[string]interface{})
 	err := dec.Decode(&temp)
 	if err != nil {
-		return "", "", err
+		return Entry{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
 	}
 
-	id, ok := temp["id"].(string)
+	var entry Entry
+	var ok bool
+
+	entry.Id, ok = temp["id"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("id field not found in unpackData")
+		return Entry{}, fmt.Errorf("%w: id field not found in unpackData", CorruptEntryErr)
 	}
 
-	data, ok := temp["data"].(string)
+	entry.Password, ok = temp["data"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("data field not found in unpackData")
+		return Entry{}, fmt.Errorf("%w: data field not found in unpackData", CorruptEntryErr)
 	}
 
 	_, ok = temp["padding"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("padding field not found in unpackData")
+		return Entry{}, fmt.Errorf("%w: padding field not found in unpackData", CorruptEntryErr)
 	}
 
 	_, ok = temp["entropy"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("entropy field not found in unpackData")
+		return Entry{}, fmt.Errorf("%w: entropy field not found in unpackData", CorruptEntryErr)
 	}
 
-	_, ok = temp["timestamp"].(string)
+	timestamp, ok := temp["timestamp"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("timestamp field not found in unpackData")
+		return Entry{}, fmt.Errorf("%w: timestamp field not found in unpackData", CorruptEntryErr)
+	}
+	entry.Modified, err = time.Parse(timeFormat, timestamp)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
 	}
 
-	return id, data, nil
+	entry.Created = entry.Modified
+	if value, found := temp["created"]; found {
+		created, ok := value.(string)
+		if !ok {
+			return Entry{}, fmt.Errorf("%w: invalid created field in unpackData", CorruptEntryErr)
+		}
+		entry.Created, err = time.Parse(timeFormat, created)
+		if err != nil {
+			return Entry{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+		}
+	}
+
+	if value, found := temp["expires"]; found {
+		expires, ok := value.(string)
+		if !ok {
+			return Entry{}, fmt.Errorf("%w: invalid expires field in unpackData", CorruptEntryErr)
+		}
+		entry.Expires, err = time.Parse(timeFormat, expires)
+		if err != nil {
+			return Entry{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+		}
+	}
+
+	if value, found := temp["labels"]; found {
+		labels, ok := value.(map[string]interface{})
+		if !ok {
+			return Entry{}, fmt.Errorf("%w: invalid labels field in unpackData", CorruptEntryErr)
+		}
+		entry.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			entry.Labels[k], ok = v.(string)
+			if !ok {
+				return Entry{}, fmt.Errorf("%w: invalid label %v in unpackData", CorruptEntryErr, k)
+			}
+		}
+	}
+
+	return entry, nil
 }
 
-// Encrypt a given text with AES256 and return a base64 representation.
+// Encrypt a given text and return a base64 representation.
 // The secret is hashed with the custom Hash function.
-// Galois Counter Mode is used.
+// The cipher is AES-256-GCM, since only a Manager selects other ciphers (see Manager.SetCipher).
 // The nonce is stored as a prefix of the ciphertext.
+// A versioned header records cipher and key derivation parameters, such that later changes of Hash do not break decryption.
 func Encrypt(text string, secret string) (string, error) {
+	return encryptWithHeader(text, secret, currentHeader(), Hash, nil)
+}
+
+// encryptWithHeader encrypts text with the cipher and key derivation function of header.
+// hash is used for KDFCustom. The header is authenticated as additional data. cache may be nil.
+func encryptWithHeader(text string, secret string, header Header, hash HashFunc, cache *keyCache) (string, error) {
 	// create salt
 	salt := make([]byte, saltLength)
 	_, err := rand.Read(salt)
@@ -186,85 +277,160 @@ func Encrypt(text string, secret string) (string, error) {
 	}
 
 	// hash secret
-	secretHash := Hash([]byte(secret), salt)
+	secretBytes := []byte(secret)
+	defer clear(secretBytes)
+	secretHash, tag, cached := cache.deriveKey(header.KDF, secretBytes, salt, hash)
+	defer clear(secretHash[:])
 
 	// prepare cipher
-	block, err := aes.NewCipher(secretHash[:])
+	aead, err := newAEAD(header.Cipher, secretHash[:])
 	if err != nil {
 		return "", err
 	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+	if !cached {
+		cache.add(tag, secretHash)
 	}
 
 	// create nonce
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	_, err = rand.Read(nonce)
 	if err != nil {
 		return "", err
 	}
 
 	// encrypt
-	encrypted := gcm.Seal(nil, nonce, []byte(text), nil)
+	prefix := header.prefix()
+	encrypted := aead.Seal(nil, nonce, []byte(text), []byte(prefix))
 	saltAndNonce := append(salt, nonce...)
 	cipherBytes := append(saltAndNonce, encrypted...)
 
-	return base64.StdEncoding.EncodeToString(cipherBytes), nil
+	return prefix + base64.StdEncoding.EncodeToString(cipherBytes), nil
 }
 
-// Decrypt a given ciphertext in base64 representation with AES256.
-// The secret is hashed with the custom Hash function.
-// Galois Counter Mode is used.
+// Decrypt a given ciphertext in base64 representation.
+// The cipher and the key derivation function of the secret are read from the ciphertext header,
+// e.g. AES-256-GCM or XChaCha20-Poly1305 (see Manager.SetCipher).
 // The nonce is retrieved as a prefix of the ciphertext.
+// Legacy ciphertexts without a header are decrypted with AES-256-GCM and the current Hash function.
 func Decrypt(ciphertext string, secret string) (string, error) {
-	// extract salt
-	cipherBytes, err := base64.StdEncoding.DecodeString(ciphertext)
+	return decryptWithHash(ciphertext, secret, Hash, nil)
+}
+
+// decryptWithHash decrypts like Decrypt, but uses hash for legacy ciphertexts and KDFCustom.
+// Keys are only added to cache after successful authentication. cache may be nil.
+func decryptWithHash(ciphertext string, secret string, hash HashFunc, cache *keyCache) (string, error) {
+	// extract header
+	header, payload, err := splitHeader(ciphertext)
 	if err != nil {
 		return "", err
 	}
+	additionalData := []byte(nil)
+	if header.IsLegacy() {
+		header.Cipher = CipherAES256GCM
+	} else {
+		additionalData = []byte(header.prefix())
+	}
+
+	// extract salt
+	cipherBytes, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
 	if len(cipherBytes) < saltLength {
-		return "", fmt.Errorf("ciphertext is too short")
+		return "", fmt.Errorf("%w: ciphertext is too short", CorruptEntryErr)
 	}
 	salt := cipherBytes[:saltLength]
 	cipherBytes = cipherBytes[saltLength:]
 
 	// hash secret
-	secretHash := Hash([]byte(secret), salt)
+	secretBytes := []byte(secret)
+	defer clear(secretBytes)
+	secretHash, tag, cached := cache.deriveKey(header.KDF, secretBytes, salt, hash)
+	defer clear(secretHash[:])
 
 	// prepare cipher
-	block, err := aes.NewCipher(secretHash[:])
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	aead, err := newAEAD(header.Cipher, secretHash[:])
 	if err != nil {
 		return "", err
 	}
 
 	// extract nonce
-	if len(cipherBytes) < gcm.NonceSize() {
-		return "", fmt.Errorf("ciphertext is too short")
+	if len(cipherBytes) < aead.NonceSize() {
+		return "", fmt.Errorf("%w: ciphertext is too short", CorruptEntryErr)
 	}
-	nonce := cipherBytes[:gcm.NonceSize()]
-	msg := cipherBytes[gcm.NonceSize():]
+	nonce := cipherBytes[:aead.NonceSize()]
+	msg := cipherBytes[aead.NonceSize():]
 
 	// decrypt
-	textBytes, err := gcm.Open(nil, nonce, msg, nil)
+	textBytes, err := aead.Open(nil, nonce, msg, additionalData)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", AuthenticationErr, err)
+	}
+	if !cached {
+		cache.add(tag, secretHash)
 	}
 
 	if !utf8.Valid(textBytes) {
-		return "", fmt.Errorf("invalid utf8 character after decryption")
+		return "", fmt.Errorf("%w: invalid utf8 character after decryption", CorruptEntryErr)
 	}
 	text := string(textBytes)
 
 	return text, nil
 }
 
+// newAEAD returns the authenticated cipher of id with a raw 32 byte key.
+func newAEAD(id CipherId, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("%w: %v", UnsupportedCipherErr, id)
+	}
+}
+
+// sealAEAD encrypts plaintext with the cipher of id and a raw 32 byte key and returns nonce + ciphertext.
+// additionalData is authenticated but not encrypted and may be nil.
+func sealAEAD(id CipherId, key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAEAD decrypts nonce + ciphertext from sealAEAD with the cipher of id and a raw 32 byte key.
+// additionalData must match the one of sealAEAD.
+func openAEAD(id CipherId, key []byte, data []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext is too short", CorruptEntryErr)
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", AuthenticationErr, err)
+	}
+
+	return plaintext, nil
+}
+
 // EncryptOTP returns a One-Time-Pad (OTP) encrypted message and its OTP secret.
 func EncryptOTP(text string) ([]byte, []byte) {
 	secret := make([]byte, len(text))
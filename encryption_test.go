@@ -1,7 +1,10 @@
 package password
 
 import (
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_Encrypt_Decrypt(t *testing.T) {
@@ -64,6 +67,8 @@ func Test_EncryptOTP_DecryptOTP(t *testing.T) {
 func Test_compareHashedPassword(t *testing.T) {
 	type args struct {
 		password string
+		other    string
+		legacy   bool
 	}
 	tests := []struct {
 		name    string
@@ -71,16 +76,25 @@ func Test_compareHashedPassword(t *testing.T) {
 		want    bool
 		wantErr bool
 	}{
-		{"success", args{"foo"}, true, false},
+		{"success", args{"foo", "foo", false}, true, false},
+		{"failure", args{"foo", "bar", false}, false, false},
+		{"legacy success", args{"foo", "foo", true}, true, false},
+		{"legacy failure", args{"foo", "bar", true}, false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hashedPassword, err := getHashedPassword(tt.args.password)
+			var hashedPassword string
+			var err error
+			if tt.args.legacy {
+				hashedPassword, err = legacyHashedPassword(tt.args.password)
+			} else {
+				hashedPassword, err = getHashedPassword(tt.args.password, Argon2Params{1, 64, 1})
+			}
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getHashedPassword() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			got, err := compareHashedPassword(hashedPassword, tt.args.password)
+			got, err := compareHashedPassword(hashedPassword, tt.args.other)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("compareHashedPassword() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -118,7 +132,7 @@ func Test_packData_unpackData(t *testing.T) {
 				return
 			}
 
-			paddingAndDataLen := len(got) - len(tt.args.id) - len(timeFormat) - 93
+			paddingAndDataLen := len(got) - len(tt.args.id) - 2*len(timeFormat) - 106
 			if paddingAndDataLen != tt.wantLen {
 				t.Errorf("len(packData() - ...) got = %v, want %v", paddingAndDataLen, tt.wantLen)
 			}
@@ -137,3 +151,86 @@ func Test_packData_unpackData(t *testing.T) {
 		})
 	}
 }
+
+func Test_packEntry_unpackEntry(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	modified := time.Date(2021, 6, 7, 8, 9, 10, 0, time.FixedZone("", -7200))
+	tests := []struct {
+		name  string
+		entry Entry
+	}{
+		{"no labels", Entry{"foo", "bar", created, modified, nil, time.Time{}}},
+		{"labels", Entry{"foo", "bar", created, modified, map[string]string{"owner": "me", "env": "prod<>&"}, time.Time{}}},
+		{"expires", Entry{"foo", "bar", created, modified, nil, modified.Add(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed, err := packEntry(tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := unpackEntry(packed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Id != tt.entry.Id || got.Password != tt.entry.Password {
+				t.Errorf("unpackEntry() got = %v, want %v", got, tt.entry)
+			}
+			if !got.Created.Equal(tt.entry.Created) || !got.Modified.Equal(tt.entry.Modified) {
+				t.Errorf("unpackEntry() times = %v, %v, want %v, %v", got.Created, got.Modified, tt.entry.Created, tt.entry.Modified)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.entry.Labels) {
+				t.Errorf("unpackEntry() labels = %v, want %v", got.Labels, tt.entry.Labels)
+			}
+			if !got.Expires.Equal(tt.entry.Expires) {
+				t.Errorf("unpackEntry() expires = %v, want %v", got.Expires, tt.entry.Expires)
+			}
+		})
+	}
+}
+
+func Test_unpackEntry(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantCreated  string
+		wantModified string
+		wantLabels   map[string]string
+		wantErr      error
+	}{
+		{"legacy", `{"data":"bar","entropy":"","id":"foo","padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"2020-01-02T03:04:05+01:00", "2020-01-02T03:04:05+01:00", nil, nil},
+		{"created", `{"created":"2019-01-02T03:04:05+01:00","data":"bar","entropy":"","id":"foo","padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"2019-01-02T03:04:05+01:00", "2020-01-02T03:04:05+01:00", nil, nil},
+		{"labels", `{"data":"bar","entropy":"","id":"foo","labels":{"a":"b"},"padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"2020-01-02T03:04:05+01:00", "2020-01-02T03:04:05+01:00", map[string]string{"a": "b"}, nil},
+		{"invalid timestamp", `{"data":"bar","entropy":"","id":"foo","padding":" ","timestamp":"yesterday"}`,
+			"", "", nil, CorruptEntryErr},
+		{"invalid created", `{"created":1,"data":"bar","entropy":"","id":"foo","padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"", "", nil, CorruptEntryErr},
+		{"invalid expires", `{"data":"bar","entropy":"","expires":"tomorrow","id":"foo","padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"", "", nil, CorruptEntryErr},
+		{"invalid labels", `{"data":"bar","entropy":"","id":"foo","labels":{"a":1},"padding":" ","timestamp":"2020-01-02T03:04:05+01:00"}`,
+			"", "", nil, CorruptEntryErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unpackEntry(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unpackEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if created := got.Created.Format(timeFormat); created != tt.wantCreated {
+				t.Errorf("unpackEntry() created = %v, want %v", created, tt.wantCreated)
+			}
+			if modified := got.Modified.Format(timeFormat); modified != tt.wantModified {
+				t.Errorf("unpackEntry() modified = %v, want %v", modified, tt.wantModified)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+				t.Errorf("unpackEntry() labels = %v, want %v", got.Labels, tt.wantLabels)
+			}
+		})
+	}
+}
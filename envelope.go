@@ -0,0 +1,376 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/image357/password/log"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyringId is the reserved storage id of the keyring that holds the wrapped key-encryption key of envelope encryption.
+const KeyringId string = ".keyring"
+
+// envelopePrefix marks ciphertexts with a cipher id and a wrapped per-entry data key.
+// The colon is not part of the base64 alphabet, i.e. legacy ciphertexts never carry this prefix.
+const envelopePrefix = "env2:"
+
+// envelopeSeparator separates the wrapped data key from the ciphertext.
+const envelopeSeparator = ":"
+
+// envelopeKeyLength is the length of key-encryption and data keys.
+const envelopeKeyLength = 32
+
+// ReservedIdErr is wrapped if an operation targets an id that is reserved for internal use, e.g. KeyringId.
+var ReservedIdErr = errors.New("reserved id")
+
+// EnableEnvelopeEncryption will encrypt new entries with random per-entry data keys.
+// Data keys are wrapped by a key-encryption key that is stored in the keyring entry (KeyringId).
+// The keyring is encrypted with the storage key of the first write, which becomes the master key of the store.
+// Only the first access to the keyring runs the Hash function. Afterward, Get and Check only need AES operations.
+// Entries are readable regardless of this setting.
+func (m *Manager) EnableEnvelopeEncryption() {
+	m.envelope = true
+}
+
+// DisableEnvelopeEncryption will encrypt new entries directly with the storage key again.
+// The cached key-encryption key is wiped.
+func (m *Manager) DisableEnvelopeEncryption() {
+	m.envelope = false
+
+	m.keyringMutex.Lock()
+	defer m.keyringMutex.Unlock()
+	m.resetKeyringCache()
+}
+
+// RotateMasterKey changes the master key of the keyring from oldKey to newKey.
+// Entries are not rewritten, since their data keys stay wrapped by the same key-encryption key.
+// If enabled, recovery entries that store oldKey are recreated with newKey.
+// Recovery entries that are created while RotateMasterKey runs are not recreated.
+func (m *Manager) RotateMasterKey(oldKey string, newKey string) error {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return err
+	}
+
+	// lock the keyring and all passwords with recovery entries in a fixed order
+	recoveryIds := make([]string, 0)
+	lockIds := []string{KeyringId}
+	if m.withRecovery {
+		for _, id := range ids {
+			if owner, found := strings.CutSuffix(id, RecoveryIdSuffix); found {
+				recoveryIds = append(recoveryIds, id)
+				lockIds = append(lockIds, owner)
+			}
+		}
+	}
+	slices.Sort(lockIds)
+	for _, id := range lockIds {
+		m.lockId(id)
+		defer m.unlockId(id)
+	}
+
+	m.keyringMutex.Lock()
+	defer m.keyringMutex.Unlock()
+
+	encryptedData, err := m.storageBackend.Retrieve(KeyringId)
+	if err != nil {
+		return err
+	}
+
+	packedData, err := m.decryptWithKey(encryptedData, oldKey)
+	if err != nil {
+		return err
+	}
+
+	newData, err := m.encryptWithKey(packedData, newKey)
+	if err != nil {
+		return err
+	}
+
+	err = m.storageBackend.Store(KeyringId, newData)
+	if err != nil {
+		return err
+	}
+	m.resetKeyringCache()
+
+	m.rotateRecoveryStorageKey(recoveryIds, oldKey, newKey)
+
+	return nil
+}
+
+// rotateRecoveryStorageKey recreates the recovery entries of recoveryIds that store oldKey with newKey.
+// Their passwords must be locked.
+func (m *Manager) rotateRecoveryStorageKey(recoveryIds []string, oldKey string, newKey string) {
+	for _, id := range recoveryIds {
+		if m.hasRecoveryPublicKey() {
+			// public key recovery entries cannot be read, but envelope entries always use the master key
+			data, err := m.storageBackend.Retrieve(strings.TrimSuffix(id, RecoveryIdSuffix))
+			if err != nil || !isEnvelope(data) {
+				continue
+			}
+		} else {
+			matches := false
+			err := m.useRecoveryKey(func(recoveryKey string) {
+				storedKey, err := m.Get(id, recoveryKey)
+				matches = err == nil && comparePassword(storedKey, oldKey)
+			})
+			if err != nil || !matches {
+				continue
+			}
+		}
+
+		err := m.writeRecovery(id, newKey)
+		if err != nil {
+			log.Warn("cannot write recovery key file", "id", id)
+		}
+	}
+}
+
+// checkReservedId returns ReservedIdErr for normalized ids that must not be accessed as passwords,
+// i.e. the keyring and version entries.
+func checkReservedId(id string) error {
+	if id == KeyringId || isHistoryId(id) {
+		return fmt.Errorf("%w: %v", ReservedIdErr, id)
+	}
+	return nil
+}
+
+// storeSealed stores the ciphertext of seal as id. Clean waits until the ciphertext is stored, since it would otherwise
+// delete the keyring after seal wrapped a data key with it, which leaves an entry that cannot be decrypted.
+func (m *Manager) storeSealed(id string, seal func() (string, error)) error {
+	m.keyringWriteMutex.RLock()
+	defer m.keyringWriteMutex.RUnlock()
+
+	encryptedData, err := seal()
+	if err != nil {
+		return err
+	}
+	return m.storageBackend.Store(id, encryptedData)
+}
+
+// encrypt a packed entry of a normalized id with key.
+// Envelope encryption is used if enabled, except for recovery entries, which must stay readable with the recovery key alone.
+func (m *Manager) encrypt(id string, text string, key string) (string, error) {
+	if !m.envelope || strings.HasSuffix(id, RecoveryIdSuffix) {
+		return m.encryptWithKey(text, key)
+	}
+
+	kek, err := m.keyringKey(key, true)
+	if err != nil {
+		return "", err
+	}
+	defer clear(kek)
+
+	return encryptEnvelope(text, kek, m.GetCipher(), envelopeData(id))
+}
+
+// decrypt a stored ciphertext of a normalized id with key. The format is detected automatically.
+func (m *Manager) decrypt(id string, ciphertext string, key string) (string, error) {
+	if isSlotted(ciphertext) {
+		return m.decryptSlots(ciphertext, key)
+	}
+	if !isEnvelope(ciphertext) {
+		return m.decryptWithKey(ciphertext, key)
+	}
+
+	kek, err := m.keyringKey(key, false)
+	if err != nil {
+		return "", err
+	}
+	defer clear(kek)
+
+	return decryptEnvelope(ciphertext, kek, envelopeData(id))
+}
+
+// envelopeData returns the associated data that binds an envelope ciphertext to a normalized id.
+// Versions hold the ciphertexts of their password and are bound to the password id.
+func envelopeData(id string) []byte {
+	return []byte(historyOwner(id))
+}
+
+// isEnvelope reports whether a ciphertext was created by encryptEnvelope.
+func isEnvelope(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, envelopePrefix)
+}
+
+// encryptEnvelope encrypts text with a random data key and wraps the data key with kek.
+// Both operations use the cipher of id and authenticate additionalData.
+func encryptEnvelope(text string, kek []byte, id CipherId, additionalData []byte) (string, error) {
+	dek := make([]byte, envelopeKeyLength)
+	_, err := rand.Read(dek)
+	if err != nil {
+		return "", err
+	}
+	defer clear(dek)
+
+	wrappedKey, err := sealAEAD(id, kek, dek, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	cipherBytes, err := sealAEAD(id, dek, []byte(text), additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return envelopePrefix +
+		strconv.Itoa(int(id)) +
+		envelopeSeparator +
+		base64.StdEncoding.EncodeToString(wrappedKey) +
+		envelopeSeparator +
+		base64.StdEncoding.EncodeToString(cipherBytes), nil
+}
+
+// decryptEnvelope unwraps the data key with kek and decrypts the ciphertext of encryptEnvelope.
+// additionalData must match the one of encryptEnvelope.
+func decryptEnvelope(ciphertext string, kek []byte, additionalData []byte) (string, error) {
+	idText, payload, found := strings.Cut(strings.TrimPrefix(ciphertext, envelopePrefix), envelopeSeparator)
+	if !found {
+		return "", fmt.Errorf("%w: missing envelope separator", CorruptEntryErr)
+	}
+	n, err := strconv.ParseUint(idText, 10, 8)
+	if err != nil || !CipherId(n).valid() {
+		return "", fmt.Errorf("%w: unknown cipher %v", CorruptEntryErr, idText)
+	}
+	id := CipherId(n)
+
+	wrappedText, cipherText, found := strings.Cut(payload, envelopeSeparator)
+	if !found {
+		return "", fmt.Errorf("%w: missing envelope separator", CorruptEntryErr)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(wrappedText)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	cipherBytes, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+
+	dek, err := openAEAD(id, kek, wrappedKey, additionalData)
+	if err != nil {
+		return "", err
+	}
+	defer clear(dek)
+	if len(dek) != envelopeKeyLength {
+		return "", fmt.Errorf("%w: invalid data key length", CorruptEntryErr)
+	}
+
+	textBytes, err := openAEAD(id, dek, cipherBytes, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	if !utf8.Valid(textBytes) {
+		return "", fmt.Errorf("%w: invalid utf8 character after decryption", CorruptEntryErr)
+	}
+	return string(textBytes), nil
+}
+
+// keyringTag returns a keyed hash that identifies the storage key which unlocked the cached keyring.
+// The keyring mutex must be held.
+func (m *Manager) keyringTag(key string) []byte {
+	if m.keyringSalt == nil {
+		m.keyringSalt = make([]byte, saltLength)
+		_, _ = rand.Read(m.keyringSalt)
+	}
+	keyBytes := []byte(key)
+	defer clear(keyBytes)
+	mac := hmac.New(sha256.New, m.keyringSalt)
+	mac.Write(keyBytes)
+	return mac.Sum(nil)
+}
+
+// resetKeyringCache wipes the cached key-encryption key. The keyring mutex must be held.
+func (m *Manager) resetKeyringCache() {
+	m.keyringKeyBuffer.Destroy()
+	m.keyringKeyBuffer, m.keyringKeyTag = nil, nil
+}
+
+// keyringKey returns a copy of the key-encryption key of the keyring that is unlocked by key. The caller should wipe the copy after use.
+// If create is true, a missing keyring is created with key as master key.
+// The key-encryption key is always read from the stored keyring, since another manager may have created it first.
+// It is cached, such that the Hash function only runs once per master key.
+func (m *Manager) keyringKey(key string, create bool) ([]byte, error) {
+	m.keyringMutex.Lock()
+	defer m.keyringMutex.Unlock()
+
+	tag := m.keyringTag(key)
+	if m.keyringKeyTag != nil && hmac.Equal(tag, m.keyringKeyTag) {
+		return m.keyringKeyBuffer.Bytes(), nil
+	}
+
+	encryptedData, err := m.storageBackend.Retrieve(KeyringId)
+	if errors.Is(err, NotFoundErr) && create {
+		err = m.createKeyring(key)
+		if err != nil {
+			return nil, err
+		}
+		encryptedData, err = m.storageBackend.Retrieve(KeyringId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	packedData, err := m.decryptWithKey(encryptedData, key)
+	if err != nil {
+		return nil, err
+	}
+
+	storedId, encodedKey, err := unpackData(packedData)
+	if err != nil {
+		return nil, err
+	}
+	if storedId != KeyringId {
+		return nil, fmt.Errorf("%w: got %v, want %v", IdMismatchErr, storedId, KeyringId)
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	if len(kek) != envelopeKeyLength {
+		return nil, fmt.Errorf("%w: invalid keyring key length", CorruptEntryErr)
+	}
+
+	m.resetKeyringCache()
+	m.keyringKeyBuffer = NewSecureBuffer(kek)
+	m.keyringKeyTag = tag
+	return kek, nil
+}
+
+// createKeyring stores a new random key-encryption key that is encrypted with key, unless the keyring exists.
+// Storage backends that implement createStorage create it atomically. Otherwise, concurrent managers may replace
+// each other's keyring. The keyring mutex must be held.
+func (m *Manager) createKeyring(key string) error {
+	kek := make([]byte, envelopeKeyLength)
+	defer clear(kek)
+	_, err := rand.Read(kek)
+	if err != nil {
+		return err
+	}
+
+	packedData, err := packData(KeyringId, base64.StdEncoding.EncodeToString(kek))
+	if err != nil {
+		return err
+	}
+
+	encryptedData, err := m.encryptWithKey(packedData, key)
+	if err != nil {
+		return err
+	}
+
+	if s, ok := m.storageBackend.(createStorage); ok {
+		_, err = s.Create(KeyringId, encryptedData)
+		return err
+	}
+	return m.storageBackend.Store(KeyringId, encryptedData)
+}
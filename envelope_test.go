@@ -0,0 +1,277 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager_EnvelopeEncryption(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Storage
+	}{
+		{"FileStorage", NewFileStorage()},
+		{"TemporaryStorage", NewTemporaryStorage()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			hashCalls := 0
+			Hash = func(data []byte, salt []byte) [32]byte {
+				hashCalls++
+				return sha256Hash(data, salt)
+			}
+			m := NewManagerWithStorage(tt.backend)
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_EnvelopeEncryption")
+			}
+
+			// legacy entry before envelope encryption
+			err := m.Overwrite("legacy", "old", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m.EnableEnvelopeEncryption()
+			m.EnableRecovery("rec")
+			for _, id := range []string{"foo", "bar"} {
+				err = m.Overwrite(id, id+"123", "456")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			encryptedData, err := tt.backend.Retrieve("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encryptedData, envelopePrefix) {
+				t.Errorf("Retrieve() = %v, want prefix %v", encryptedData, envelopePrefix)
+			}
+
+			// Get does not hash after the keyring was unlocked
+			hashCalls = 0
+			for _, id := range []string{"foo", "bar"} {
+				got, err := m.Get(id, "456")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != id+"123" {
+					t.Errorf("Get() = %v, want %v", got, id+"123")
+				}
+			}
+			if hashCalls != 0 {
+				t.Errorf("Get() hash calls = %v, want 0", hashCalls)
+			}
+			got, err := m.Get("legacy", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "old" {
+				t.Errorf("Get() = %v, want old", got)
+			}
+
+			// wrong key
+			_, err = m.Get("foo", "789")
+			if !errors.Is(err, AuthenticationErr) {
+				t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+			}
+
+			// ciphertexts are bound to their id
+			err = tt.backend.Store("baz", encryptedData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = m.Get("baz", "456")
+			if !errors.Is(err, AuthenticationErr) {
+				t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+			}
+			err = m.Delete("baz")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// keyring is hidden and reserved
+			ids, err := m.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slices.Contains(ids, KeyringId) {
+				t.Errorf("List() = %v, want without %v", ids, KeyringId)
+			}
+			_, err = m.Get(KeyringId, "456")
+			if !errors.Is(err, ReservedIdErr) {
+				t.Errorf("Get() error = %v, want %v", err, ReservedIdErr)
+			}
+			err = m.Overwrite(KeyringId, "123", "456")
+			if !errors.Is(err, ReservedIdErr) {
+				t.Errorf("Overwrite() error = %v, want %v", err, ReservedIdErr)
+			}
+			err = m.Delete(KeyringId)
+			if !errors.Is(err, ReservedIdErr) {
+				t.Errorf("Delete() error = %v, want %v", err, ReservedIdErr)
+			}
+
+			// rotate master key without rewriting entries
+			err = m.RotateMasterKey("456", "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotatedData, err := tt.backend.Retrieve("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotatedData != encryptedData {
+				t.Errorf("RotateMasterKey() changed entry data")
+			}
+			got, err = m.Get("foo", "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "foo123" {
+				t.Errorf("Get() = %v, want foo123", got)
+			}
+			_, err = m.Get("foo", "456")
+			if !errors.Is(err, AuthenticationErr) {
+				t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+			}
+			storedKey, err := m.Get("foo"+RecoveryIdSuffix, "rec")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storedKey != "789" {
+				t.Errorf("Get() recovery key = %v, want 789", storedKey)
+			}
+
+			// envelope entries stay readable after disabling
+			m.DisableEnvelopeEncryption()
+			got, err = m.Get("bar", "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "bar123" {
+				t.Errorf("Get() = %v, want bar123", got)
+			}
+
+			// cleanup test
+			Hash = oldHash
+			err = m.Clean()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_EnvelopeEncryption_concurrentKeyring(t *testing.T) {
+	path := "./tests/workdir/Manager_EnvelopeEncryption_concurrentKeyring"
+	for round := 0; round < 20; round++ {
+		// init test: several managers share one store, e.g. several processes
+		managers := make([]*Manager, 8)
+		for i := range managers {
+			backend := NewFileStorage()
+			backend.SetStorePath(path)
+			managers[i] = NewManagerWithStorage(backend)
+			managers[i].SetHashFunc(sha256Hash)
+			managers[i].EnableEnvelopeEncryption()
+		}
+
+		// all managers create the keyring at the same time
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i, m := range managers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				err := m.Overwrite("id"+strconv.Itoa(i), "123", "456")
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		// the entries of all managers are readable by a new manager
+		backend := NewFileStorage()
+		backend.SetStorePath(path)
+		m := NewManagerWithStorage(backend)
+		m.SetHashFunc(sha256Hash)
+		for i := range managers {
+			got, err := m.Get("id"+strconv.Itoa(i), "456")
+			if err != nil || got != "123" {
+				t.Errorf("round %v: Get() of id%v = %v, %v, want 123", round, i, got, err)
+			}
+		}
+
+		// cleanup test
+		err := os.RemoveAll(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// slowStoreStorage delays Store of one id after signalling that the write started.
+type slowStoreStorage struct {
+	*TemporaryStorage
+	id      string
+	storing chan struct{}
+}
+
+func (s *slowStoreStorage) Store(id string, data string) error {
+	if id == s.id {
+		close(s.storing)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return s.TemporaryStorage.Store(id, data)
+}
+
+func TestManager_EnvelopeEncryption_concurrentClean(t *testing.T) {
+	// init test
+	backend := &slowStoreStorage{NewTemporaryStorage(), "foo", make(chan struct{})}
+	m := NewManagerWithStorage(backend)
+	m.SetHashFunc(sha256Hash)
+	m.EnableEnvelopeEncryption()
+
+	// clean while foo is sealed, but not stored yet
+	done := make(chan error)
+	go func() {
+		done <- m.Overwrite("foo", "123", "456")
+	}()
+	<-backend.storing
+	err := m.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new keyring is created, but foo was either cleaned or is still readable
+	err = m.Overwrite("bar", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get("foo", "456")
+	if err != nil && !errors.Is(err, NotFoundErr) {
+		t.Errorf("Get() error = %v, want nil or %v", err, NotFoundErr)
+	}
+}
@@ -3,15 +3,18 @@ package password
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/image357/password/log"
 	"io/fs"
 	"os"
 	pathlib "path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -27,6 +30,12 @@ const storageFileMode os.FileMode = 0600
 // storageDirMode controls the directory permission set by this package.
 const storageDirMode os.FileMode = 0700
 
+// tempFileEnding is the file extension of temporary files that are written by FileStorage.Store.
+const tempFileEnding string = ".tmp"
+
+// staleTempFileAge is the minimum age of temporary files before they are treated as leftovers of interrupted writes.
+const staleTempFileAge = time.Minute
+
 // FileStorage is a file based storage backend.
 type FileStorage struct {
 	// storePath holds the absolute storage path.
@@ -59,6 +68,7 @@ func (f *FileStorage) GetStorePath() string {
 }
 
 // SetStorePath accepts a new storage path with system-unspecific or mixed path separators.
+// Stale temporary files of interrupted writes are removed from the new storage path.
 func (f *FileStorage) SetStorePath(path string) {
 	temp, err := filepath.Abs(path)
 	if err != nil {
@@ -68,6 +78,114 @@ func (f *FileStorage) SetStorePath(path string) {
 	}
 	path = normalizeSeparator(path)
 	f.storePath = pathlib.Clean(path)
+
+	f.removeStaleTempFiles()
+}
+
+// tempFilePath returns the path of the temporary file that is used by writeFileAtomic for filePath.
+func tempFilePath(filePath string) string {
+	folderPath, fileName := filepath.Split(filePath)
+	return filepath.Join(folderPath, "."+fileName+tempFileEnding)
+}
+
+// isTempFile tests if a file name belongs to a temporary file of a password file.
+// Besides the fixed names of writeFileAtomic, names with a random suffix (e.g. from os.CreateTemp) are matched.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") &&
+		strings.Contains(name, "."+DefaultFileEnding+tempFileEnding) &&
+		!strings.HasSuffix(name, "."+DefaultFileEnding)
+}
+
+// removeStaleTempFile deletes a leftover temporary file of an interrupted Store call.
+// Temporary files younger than staleTempFileAge are kept, since they might belong to a concurrent write.
+func removeStaleTempFile(path string, d fs.DirEntry) {
+	info, err := d.Info()
+	if err != nil || time.Since(info.ModTime()) < staleTempFileAge {
+		return
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn("cannot remove stale temporary file", "path", path)
+	}
+}
+
+// removeStaleTempFiles deletes leftover temporary files of interrupted Store calls from the storage path.
+// Folders without a store-wide lock file have never been written by FileStorage and are skipped.
+func (f *FileStorage) removeStaleTempFiles() {
+	_, err := os.Stat(f.storeLockPath())
+	if err != nil {
+		return
+	}
+
+	_ = filepath.WalkDir(f.GetStorePath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && isTempFile(d.Name()) {
+			removeStaleTempFile(path, d)
+		}
+		return nil
+	})
+}
+
+// syncDir flushes the directory entries of path to disk, e.g. after a rename.
+// Directories cannot be synced on Windows, where this function does nothing.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// writeFileAtomic writes data to a temporary file in the same folder, syncs it to disk and renames it to filePath.
+// The file at filePath always contains either the previous or the new data, even if the process crashes mid-write.
+// Leftovers of previously interrupted writes are overwritten.
+// The caller must hold an exclusive lock on filePath, since the temporary file path is fixed.
+func writeFileAtomic(filePath string, data []byte) error {
+	tempPath := tempFilePath(filePath)
+
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, storageFileMode)
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Chmod(storageFileMode)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	folderPath, _ := filepath.Split(filePath)
+	if folderPath == "" {
+		folderPath = "."
+	}
+	return syncDir(folderPath)
 }
 
 // FilePath returns the storage filepath of a given password-id with system-specific path separators.
@@ -77,6 +195,14 @@ func (f *FileStorage) FilePath(id string) string {
 	return filepath.FromSlash(pathlib.Join(f.storePath, id+"."+DefaultFileEnding))
 }
 
+// wrapNotExist wraps NotFoundErr around errors that report a missing file.
+func wrapNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", NotFoundErr, err)
+	}
+	return err
+}
+
 // lockId locks a storage id mutex by first locking the storage tree and increasing lock count.
 func (f *FileStorage) lockId(id string) {
 	id = NormalizeId(id)
@@ -127,12 +253,142 @@ func (f *FileStorage) unlockId(id string) {
 	f.storageTreeMutex.Unlock()
 }
 
+// storeLockFile is the name of the store-wide lock file inside the storage path.
+const storeLockFile string = ".store.lock"
+
+// lockFileEnding is the file extension of per-id lock files.
+const lockFileEnding string = ".lock"
+
+// fileLock holds an OS-level advisory lock on an open lock file.
+type fileLock struct {
+	file *os.File
+}
+
+// acquireFileLock opens the lock file at path and blocks until the lock is acquired.
+// The lock file is created if create is true.
+// A nil lock without error is returned if the lock file (or its folder) does not exist, i.e. there is nothing to protect.
+func acquireFileLock(path string, exclusive bool, create bool) (*fileLock, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+
+	for {
+		file, err := os.OpenFile(path, flag, storageFileMode)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = lockFile(file, exclusive)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+
+		// Delete removes lock files while holding them, i.e. the lock must be taken on the current file
+		if !isRemovedFile(file, path) {
+			return &fileLock{file: file}, nil
+		}
+		_ = unlockFile(file)
+		_ = file.Close()
+	}
+}
+
+// isRemovedFile tests if an open file was removed from path or replaced by another file.
+func isRemovedFile(file *os.File, path string) bool {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(fileInfo, pathInfo)
+}
+
+// release unlocks and closes the lock file. It is safe to call release on a nil lock.
+func (l *fileLock) release() {
+	if l == nil {
+		return
+	}
+
+	err := unlockFile(l.file)
+	if err != nil {
+		log.Warn("cannot unlock file", "path", l.file.Name(), "error", err)
+	}
+	_ = l.file.Close()
+}
+
+// storeLockPath returns the filepath of the store-wide lock file.
+func (f *FileStorage) storeLockPath() string {
+	return filepath.Join(f.GetStorePath(), storeLockFile)
+}
+
+// idLockPath returns the filepath of the lock file of a given password-id.
+func (f *FileStorage) idLockPath(id string) string {
+	folderPath, fileName := filepath.Split(f.FilePath(id))
+	return filepath.Join(folderPath, "."+fileName+lockFileEnding)
+}
+
+// acquireStore locks the whole storage path for other processes.
+// Operations on single ids acquire the store-wide lock in shared mode.
+// Store-wide operations (Clean, DumpJSON, LoadJSON) acquire it in exclusive mode.
+// Only writers set create to true, i.e. read-only operations never create the lock file.
+func (f *FileStorage) acquireStore(exclusive bool, create bool) (*fileLock, error) {
+	return acquireFileLock(f.storeLockPath(), exclusive, create)
+}
+
+// acquireId locks an id for other goroutines (via lockId) and for other processes (via lock files).
+// Writers must set exclusive to true. The returned function releases all locks.
+// The locking order is: store-wide lock, id mutex, id lock file.
+// Writers create the folder of id after the store-wide lock is held, since Delete removes empty folders
+// while holding it exclusively.
+func (f *FileStorage) acquireId(id string, exclusive bool) (func(), error) {
+	storeLock, err := f.acquireStore(false, exclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	if exclusive {
+		folderPath, _ := filepath.Split(f.FilePath(id))
+		if folderPath != "" {
+			err = os.MkdirAll(folderPath, storageDirMode)
+			if err != nil {
+				storeLock.release()
+				return nil, err
+			}
+		}
+	}
+
+	f.lockId(id)
+
+	idLock, err := acquireFileLock(f.idLockPath(id), exclusive, exclusive)
+	if err != nil {
+		f.unlockId(id)
+		storeLock.release()
+		return nil, err
+	}
+
+	return func() {
+		idLock.release()
+		f.unlockId(id)
+		storeLock.release()
+	}, nil
+}
+
 // Store (create/overwrite) the provided data in a file.
 // id is converted to the corresponding filepath.
 // If necessary, subfolders are created.
+// Data is written to a temporary file first, which then atomically replaces the password file.
+// Hence, Retrieve always returns either the old or the new data and failed writes never destroy stored passwords.
+// The id is locked for other goroutines and processes during the write.
 func (f *FileStorage) Store(id string, data string) error {
-	filePath := f.FilePath(id)
-	folderPath, _ := filepath.Split(filePath)
+	folderPath, _ := filepath.Split(f.FilePath(id))
 	if folderPath != "" {
 		err := os.MkdirAll(folderPath, storageDirMode)
 		if err != nil {
@@ -140,31 +396,69 @@ func (f *FileStorage) Store(id string, data string) error {
 		}
 	}
 
-	f.lockId(id)
-	defer f.unlockId(id)
-
-	err := os.WriteFile(filePath, []byte(data), storageFileMode)
+	release, err := f.acquireId(id, true)
 	if err != nil {
-		_ = os.Remove(filePath)
 		return err
 	}
+	defer release()
 
-	return nil
+	return f.store(id, data)
+}
+
+// Create stores data in a file only if id does not exist yet and reports whether it was stored.
+// The id is locked for other goroutines and processes between the check and the write.
+func (f *FileStorage) Create(id string, data string) (bool, error) {
+	folderPath, _ := filepath.Split(f.FilePath(id))
+	if folderPath != "" {
+		err := os.MkdirAll(folderPath, storageDirMode)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	release, err := f.acquireId(id, true)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	_, err = os.Stat(f.FilePath(id))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	return true, f.store(id, data)
+}
+
+// store writes data without any locking.
+func (f *FileStorage) store(id string, data string) error {
+	return writeFileAtomic(f.FilePath(id), []byte(data))
 }
 
 // Retrieve data from an existing file.
 // id is converted to the corresponding filepath.
 func (f *FileStorage) Retrieve(id string) (string, error) {
-	f.lockId(id)
-	defer f.unlockId(id)
+	release, err := f.acquireId(id, false)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
+	return f.retrieve(id)
+}
+
+// retrieve reads data without any locking.
+func (f *FileStorage) retrieve(id string) (string, error) {
 	textBytes, err := os.ReadFile(f.FilePath(id))
 	if err != nil {
-		return "", err
+		return "", wrapNotExist(err)
 	}
 
 	if !utf8.Valid(textBytes) {
-		return "", fmt.Errorf("invalid utf8 character after file reading")
+		return "", fmt.Errorf("%w: invalid utf8 character after file reading", CorruptEntryErr)
 	}
 	text := string(textBytes)
 
@@ -185,8 +479,42 @@ func (f *FileStorage) Exists(id string) (bool, error) {
 
 // List all stored password-ids.
 func (f *FileStorage) List() ([]string, error) {
+	storeLock, err := f.acquireStore(false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer storeLock.release()
+
+	return f.list()
+}
+
+// ListFolder lists all stored ids inside a folder, e.g. "myid.history/1" for "myid.history".
+// Only the folder is read, not the whole storage path.
+func (f *FileStorage) ListFolder(folder string) ([]string, error) {
+	storeLock, err := f.acquireStore(false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer storeLock.release()
+
+	root := filepath.FromSlash(pathlib.Join(f.storePath, NormalizeId(folder)))
+	list, err := f.walk(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make([]string, 0), nil
+	}
+	return list, err
+}
+
+// list walks the storage path without any locking.
+func (f *FileStorage) list() ([]string, error) {
+	return f.walk(f.GetStorePath())
+}
+
+// walk returns the ids of all files below root without any locking.
+// Stale temporary files are removed on the way.
+func (f *FileStorage) walk(root string) ([]string, error) {
 	list := make([]string, 0, 16)
-	err := filepath.WalkDir(f.GetStorePath(), func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -195,6 +523,11 @@ func (f *FileStorage) List() ([]string, error) {
 			return nil
 		}
 
+		if isTempFile(d.Name()) {
+			removeStaleTempFile(path, d)
+			return nil
+		}
+
 		if !strings.HasSuffix(d.Name(), "."+DefaultFileEnding) {
 			return nil
 		}
@@ -217,40 +550,109 @@ func (f *FileStorage) List() ([]string, error) {
 	return list, nil
 }
 
-// Delete an existing password.
+// Delete an existing password, its lock file and the folders of id that became empty.
 func (f *FileStorage) Delete(id string) error {
-	f.lockId(id)
-	defer f.unlockId(id)
+	release, err := f.acquireId(id, true)
+	if err != nil {
+		return err
+	}
+
+	err = f.delete(id)
+
+	// waiting processes notice the removal in acquireFileLock and lock a new file.
+	// Open files cannot be removed on Windows, where lock files are left for Clean.
+	_ = os.Remove(f.idLockPath(id))
+	release()
 
+	f.removeEmptyFolders(id)
+	return err
+}
+
+// removeEmptyFolders removes the empty folders of id inside the storage path, e.g. "myid.history" after the last version
+// was deleted. The whole storage path is locked, such that writers never lose the folder of their id (see acquireId).
+func (f *FileStorage) removeEmptyFolders(id string) {
+	storePath := filepath.Clean(f.GetStorePath())
+	folderPath := filepath.Dir(f.FilePath(id))
+	if folderPath == storePath {
+		return
+	}
+
+	storeLock, err := f.acquireStore(true, false)
+	if err != nil {
+		return
+	}
+	defer storeLock.release()
+
+	for strings.HasPrefix(folderPath, storePath+string(filepath.Separator)) {
+		// only empty folders can be removed
+		if os.Remove(folderPath) != nil {
+			return
+		}
+		folderPath = filepath.Dir(folderPath)
+	}
+}
+
+// delete removes a password file without any locking.
+func (f *FileStorage) delete(id string) error {
 	err := os.Remove(f.FilePath(id))
 	if err != nil {
-		return err
+		return wrapNotExist(err)
 	}
 
 	return nil
 }
 
 // Clean (delete) all stored passwords.
+// The whole storage path is locked for other processes, which also allows removal of all per-id lock files.
 func (f *FileStorage) Clean() error {
-	list, err := f.List()
+	storeLock, err := f.acquireStore(true, true)
+	if err != nil {
+		return err
+	}
+	defer storeLock.release()
+
+	list, err := f.list()
 	if err != nil {
 		return err
 	}
 
 	var lastErr error = nil
 	for _, l := range list {
-		err = f.Delete(l)
+		err = f.delete(l)
 		if err != nil {
 			lastErr = err
 		}
 	}
+
+	err = f.removeIdLockFiles()
+	if err != nil {
+		lastErr = err
+	}
 	return lastErr
 }
 
+// removeIdLockFiles deletes all per-id lock files from the storage path.
+// The store-wide lock must be held in exclusive mode.
+func (f *FileStorage) removeIdLockFiles() error {
+	return filepath.WalkDir(f.GetStorePath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || d.Name() == storeLockFile {
+			return nil
+		}
+
+		if !strings.HasPrefix(d.Name(), ".") || !strings.HasSuffix(d.Name(), "."+DefaultFileEnding+lockFileEnding) {
+			return nil
+		}
+
+		return os.Remove(path)
+	})
+}
+
 // DumpJSON serializes the storage backend to a JSON string.
-// Warning: This method does not block operations on the underlying storage backend (read/write/create/delete).
-// You should stop operations manually before usage or ignore the reported error.
-// Data consistency is guaranteed.
+// The whole storage path is locked during serialization, i.e. operations (read/write/create/delete) of other goroutines and processes are blocked.
 func (f *FileStorage) DumpJSON() (string, error) {
 	// prepare encoder
 	temp := new(bytes.Buffer)
@@ -258,8 +660,15 @@ func (f *FileStorage) DumpJSON() (string, error) {
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "")
 
+	// lock storage
+	storeLock, err := f.acquireStore(true, false)
+	if err != nil {
+		return "", err
+	}
+	defer storeLock.release()
+
 	// get ids
-	list, err := f.List()
+	list, err := f.list()
 	if err != nil {
 		return "", err
 	}
@@ -268,7 +677,7 @@ func (f *FileStorage) DumpJSON() (string, error) {
 	var lastErr error = nil
 	var registry = make(map[string]string)
 	for _, id := range list {
-		data, err := f.Retrieve(id)
+		data, err := f.retrieve(id)
 		if err != nil {
 			lastErr = err
 			continue
@@ -286,9 +695,7 @@ func (f *FileStorage) DumpJSON() (string, error) {
 }
 
 // LoadJSON deserializes a JSON string into the storage backend.
-// Warning: This method does not block operations on the underlying storage backend (read/write/create/delete).
-// You should stop operations manually before usage or ignore the reported error.
-// Data consistency is guaranteed.
+// The whole storage path is locked during deserialization, i.e. operations (read/write/create/delete) of other goroutines and processes are blocked.
 func (f *FileStorage) LoadJSON(input string) error {
 	// prepare decoder
 	dec := json.NewDecoder(strings.NewReader(input))
@@ -311,10 +718,25 @@ func (f *FileStorage) LoadJSON(input string) error {
 		}
 	}
 
+	// lock storage
+	err = os.MkdirAll(f.GetStorePath(), storageDirMode)
+	if err != nil {
+		return err
+	}
+	storeLock, err := f.acquireStore(true, true)
+	if err != nil {
+		return err
+	}
+	defer storeLock.release()
+
 	// write data files
 	var lastErr error = nil
 	for k, v := range temp {
-		err := f.Store(k, v.(string))
+		folderPath, _ := filepath.Split(f.FilePath(k))
+		err = os.MkdirAll(folderPath, storageDirMode)
+		if err == nil {
+			err = f.store(k, v.(string))
+		}
 		if err != nil {
 			lastErr = err
 		}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package password
+
+import (
+	"os"
+)
+
+// fileLockSupported signals that lockFile and unlockFile provide OS-level advisory locks on this platform.
+const fileLockSupported = false
+
+// lockFile does nothing, since OS-level file locks are not supported on this platform.
+func lockFile(_ *os.File, _ bool) error {
+	return nil
+}
+
+// unlockFile does nothing, since OS-level file locks are not supported on this platform.
+func unlockFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package password
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// fileLockSupported signals that lockFile and unlockFile provide OS-level advisory locks on this platform.
+const fileLockSupported = true
+
+// lockFile blocks until a LockFileEx lock is acquired on the first byte of file.
+func lockFile(file *os.File, exclusive bool) error {
+	var flags uint32 = 0
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	overlapped := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, overlapped)
+}
+
+// unlockFile releases a LockFileEx lock on the first byte of file.
+func unlockFile(file *os.File) error {
+	overlapped := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, overlapped)
+}
@@ -1,11 +1,15 @@
 package password
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewFileStorage(t *testing.T) {
@@ -294,6 +298,330 @@ func TestFileStorage_Store(t *testing.T) {
 	}
 }
 
+func TestFileStorage_Store_atomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		data    string
+		blocked bool
+		wantErr bool
+	}{
+		{"create", "some/id", "some data", false, false},
+		{"overwrite", "some/id", "another data", false, false},
+		{"blocked target", "blocked/id", "some data", true, true},
+	}
+	// init
+	f := NewFileStorage()
+	f.SetStorePath("tests/workdir/FileStorage_Store_atomic")
+
+	// tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.blocked {
+				// a folder with the same name as the password file prevents the final rename
+				err := os.MkdirAll(f.FilePath(tt.id), storageDirMode)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := f.Store(tt.id, tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("Store() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			folderPath, _ := filepath.Split(f.FilePath(tt.id))
+			entries, err := os.ReadDir(folderPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if isTempFile(e.Name()) {
+					t.Errorf("temporary file not removed: %v", e.Name())
+				}
+			}
+		})
+	}
+
+	// cleanup
+	path := f.GetStorePath()
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorage_removeStaleTempFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		age       time.Duration
+		wantExist bool
+	}{
+		{"stale temporary file", "some/.id.pwd.tmp", 2 * staleTempFileAge, false},
+		{"fresh temporary file", "some/.another.pwd.tmp", 0, true},
+		{"password file", "some/old.pwd", 2 * staleTempFileAge, true},
+		{"foreign file", "some/.foreign.tmp", 2 * staleTempFileAge, true},
+		{"random temporary file", "some/.random.pwd.tmp123456", 2 * staleTempFileAge, false},
+		{"hidden password file", "some/.hidden.pwd.tmp.pwd", 2 * staleTempFileAge, true},
+	}
+	// init
+	path := "tests/workdir/FileStorage_removeStaleTempFile"
+	err := os.MkdirAll(path, storageDirMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		file := filepath.Join(path, tt.file)
+		err := os.MkdirAll(filepath.Dir(file), storageDirMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(file, []byte("data"), storageFileMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-tt.age)
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := NewFileStorage()
+	f.SetStorePath(path)
+	_, err = f.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := os.Stat(filepath.Join(path, tt.file))
+			if exists := err == nil; exists != tt.wantExist {
+				t.Errorf("file exists = %v, want %v", exists, tt.wantExist)
+			}
+		})
+	}
+
+	// read-only calls do not create the store-wide lock file
+	_, err = f.Retrieve("some/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(f.storeLockPath())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// cleanup
+	err = os.RemoveAll(f.GetStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorage_SetStorePath_staleTempFiles(t *testing.T) {
+	// init
+	path := "tests/workdir/FileStorage_SetStorePath_staleTempFiles"
+	f := NewFileStorage()
+	f.SetStorePath(path)
+	err := f.Store("some/id", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignPath := "tests/workdir/FileStorage_SetStorePath_foreign"
+	err = os.MkdirAll(foreignPath, storageDirMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]time.Duration{
+		filepath.Join(path, "some/.stale.pwd.tmp"):     2 * staleTempFileAge,
+		filepath.Join(path, "some/.fresh.pwd.tmp"):     0,
+		filepath.Join(foreignPath, ".foreign.pwd.tmp"): 2 * staleTempFileAge,
+	}
+	for file, age := range files {
+		err = os.WriteFile(file, []byte("data"), storageFileMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// test: opening the store removes stale temporary files without any other call
+	NewFileStorage().SetStorePath(path)
+	NewFileStorage().SetStorePath(foreignPath)
+	tests := []struct {
+		name      string
+		file      string
+		wantExist bool
+	}{
+		{"stale temporary file", filepath.Join(path, "some/.stale.pwd.tmp"), false},
+		{"fresh temporary file", filepath.Join(path, "some/.fresh.pwd.tmp"), true},
+		{"password file", filepath.Join(path, "some/id.pwd"), true},
+		{"folder without store lock", filepath.Join(foreignPath, ".foreign.pwd.tmp"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := os.Stat(tt.file)
+			if exists := err == nil; exists != tt.wantExist {
+				t.Errorf("file exists = %v, want %v", exists, tt.wantExist)
+			}
+		})
+	}
+
+	// cleanup
+	for _, p := range []string{path, foreignPath} {
+		err = os.RemoveAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileStorage_acquireStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		exclusive  bool
+		other      bool
+		wantBlocks bool
+	}{
+		{"shared blocks exclusive", false, true, true},
+		{"exclusive blocks shared", true, false, true},
+		{"exclusive blocks exclusive", true, true, true},
+		{"shared allows shared", false, false, false},
+	}
+	// init
+	path := "tests/workdir/FileStorage_acquireStore"
+	err := os.MkdirAll(path, storageDirMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !fileLockSupported {
+				t.Skip("file locks are not supported on this platform")
+			}
+
+			// separate instances behave like separate processes
+			f1 := NewFileStorage()
+			f1.SetStorePath(path)
+			f2 := NewFileStorage()
+			f2.SetStorePath(path)
+
+			lock, err := f1.acquireStore(tt.exclusive, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				otherLock, err := f2.acquireStore(tt.other, true)
+				if err != nil {
+					t.Error(err)
+				}
+				otherLock.release()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				if tt.wantBlocks {
+					t.Errorf("acquireStore() did not block")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if !tt.wantBlocks {
+					t.Errorf("acquireStore() blocked")
+				}
+			}
+
+			lock.release()
+			<-done
+		})
+	}
+
+	// cleanup
+	err = os.RemoveAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorage_acquireId(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		otherId    string
+		wantBlocks bool
+	}{
+		{"same id", "some/id", "some/id", true},
+		{"other id", "some/id", "another/id", false},
+	}
+	// init
+	path := "tests/workdir/FileStorage_acquireId"
+
+	// tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !fileLockSupported {
+				t.Skip("file locks are not supported on this platform")
+			}
+
+			// separate instances behave like separate processes
+			f1 := NewFileStorage()
+			f1.SetStorePath(path)
+			f2 := NewFileStorage()
+			f2.SetStorePath(path)
+
+			err := f1.Store(tt.id, "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			release, err := f1.acquireId(tt.id, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				err := f2.Store(tt.otherId, "other data")
+				if err != nil {
+					t.Error(err)
+				}
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				if tt.wantBlocks {
+					t.Errorf("Store() did not block")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if !tt.wantBlocks {
+					t.Errorf("Store() blocked")
+				}
+			}
+
+			release()
+			<-done
+		})
+	}
+
+	// cleanup
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestFileStorage_Retrieve(t *testing.T) {
 	type args struct {
 		id string
@@ -468,6 +796,7 @@ func TestFileStorage_Delete(t *testing.T) {
 	}{
 		{"some id", args{"some/id"}, false},
 		{"missing id", args{"missing/id"}, true},
+		{"missing id in existing folder", args{"some/other"}, true},
 	}
 	// init
 	f := NewFileStorage()
@@ -484,9 +813,70 @@ func TestFileStorage_Delete(t *testing.T) {
 			if err := f.Delete(tt.args.id); (err != nil) != tt.wantErr {
 				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if _, err := os.Stat(f.idLockPath(tt.args.id)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() lock file error = %v, want %v", err, fs.ErrNotExist)
+			}
 		})
 	}
 
+	// empty folders are removed, folders with other entries are kept
+	for _, id := range []string{"a/b/c", "a/d"} {
+		err = f.Store(id, "some data")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = f.Delete("a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lock files of deleted ids are left on Windows, which keeps their folders
+	if _, err := os.Stat(filepath.Join(f.GetStorePath(), "a", "b")); !errors.Is(err, fs.ErrNotExist) && runtime.GOOS != "windows" {
+		t.Errorf("Stat() empty folder error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(filepath.Join(f.GetStorePath(), "a")); err != nil {
+		t.Errorf("Stat() folder error = %v, want nil", err)
+	}
+	err = f.Delete("a/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(f.GetStorePath(), "a")); !errors.Is(err, fs.ErrNotExist) && runtime.GOOS != "windows" {
+		t.Errorf("Stat() empty folder error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(f.GetStorePath()); err != nil {
+		t.Errorf("Stat() storage path error = %v, want nil", err)
+	}
+
+	// waiting writers lock a new file after the lock file was removed
+	if fileLockSupported {
+		other := NewFileStorage()
+		other.SetStorePath(f.GetStorePath())
+		release, err := f.acquireId("some/id", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			err := other.Store("some/id", "other data")
+			if err != nil {
+				t.Error(err)
+			}
+			close(done)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		err = os.Remove(f.idLockPath("some/id"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+		<-done
+		_, err = os.Stat(f.idLockPath("some/id"))
+		if err != nil {
+			t.Errorf("Stat() lock file error = %v, want nil", err)
+		}
+	}
+
 	// cleanup
 	path := f.GetStorePath()
 	err = os.RemoveAll(path)
@@ -530,6 +920,10 @@ func TestFileStorage_Clean(t *testing.T) {
 			if len(list) != 0 {
 				t.Errorf("Clean() list = %v, want empty", list)
 			}
+			_, err = os.Stat(f.idLockPath("some/id"))
+			if !os.IsNotExist(err) {
+				t.Errorf("Clean() did not remove id lock file, error = %v", err)
+			}
 		})
 	}
 
@@ -0,0 +1,235 @@
+package password
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// Character sets used by Generate.
+const (
+	LowercaseCharset = "abcdefghijklmnopqrstuvwxyz"
+	UppercaseCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	DigitCharset     = "0123456789"
+	SymbolCharset    = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	AmbiguousCharset = "0Oo1lI|`'\""
+)
+
+// DefaultGenerateLength is the password length of Generate if GenerateOptions.Length is zero.
+const DefaultGenerateLength = 20
+
+// DefaultWordSeparator joins passphrase words if GenerateOptions.Separator is empty.
+const DefaultWordSeparator = "-"
+
+// MaxGenerateLength is the maximum password length of Generate.
+const MaxGenerateLength = 1024
+
+// MaxGenerateWords is the maximum number of passphrase words of Generate.
+const MaxGenerateWords = 64
+
+// maxWordSeparatorLength limits the length of GenerateOptions.Separator in bytes.
+const maxWordSeparatorLength = 16
+
+// maxGenerateAttempts limits the rejection sampling of GenerateOptions.RequireEachClass.
+// Reasonable options cover all classes within a few attempts.
+const maxGenerateAttempts = 1000
+
+// GenerateOptionsErr is wrapped by Generate if the provided options cannot produce a password.
+var GenerateOptionsErr = errors.New("invalid generate options")
+
+// effLargeWordlist is the EFF large word list for diceware passphrases (CC BY 3.0 US, attribution in NOTICE).
+// See https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed wordlist/eff_large_wordlist.txt
+var effLargeWordlist string
+
+// wordlist returns the parsed words of effLargeWordlist.
+var wordlist = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(effLargeWordlist), "\n")
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		words[i] = fields[len(fields)-1]
+	}
+	return words
+})
+
+// GenerateOptions configures Generate. The zero value generates a password with DefaultGenerateLength letters and digits.
+type GenerateOptions struct {
+	// Length is the number of characters. Defaults to DefaultGenerateLength. At most MaxGenerateLength.
+	Length int `json:"length"`
+
+	// Lowercase selects LowercaseCharset.
+	Lowercase bool `json:"lowercase"`
+
+	// Uppercase selects UppercaseCharset.
+	Uppercase bool `json:"uppercase"`
+
+	// Digits selects DigitCharset.
+	Digits bool `json:"digits"`
+
+	// Symbols selects SymbolCharset.
+	Symbols bool `json:"symbols"`
+
+	// Charset is an additional custom character class.
+	Charset string `json:"charset"`
+
+	// ExcludeAmbiguous removes the characters of AmbiguousCharset from all classes.
+	ExcludeAmbiguous bool `json:"excludeAmbiguous"`
+
+	// RequireEachClass guarantees at least one character of every selected class.
+	RequireEachClass bool `json:"requireEachClass"`
+
+	// Words generates a diceware passphrase with the given number of words instead of a character password.
+	// At most MaxGenerateWords.
+	Words int `json:"words"`
+
+	// Separator joins passphrase words. Defaults to DefaultWordSeparator. At most 16 bytes.
+	Separator string `json:"separator"`
+}
+
+// Generate returns a new random password or passphrase. All randomness is drawn from crypto/rand.
+func Generate(options GenerateOptions) (string, error) {
+	if options.Words != 0 {
+		return generatePassphrase(options)
+	}
+	return generatePassword(options)
+}
+
+// generatePassword returns a random character password.
+// Class coverage is guaranteed by rejection sampling, which keeps all characters uniformly distributed.
+func generatePassword(options GenerateOptions) (string, error) {
+	length := options.Length
+	if length == 0 {
+		length = DefaultGenerateLength
+	}
+	if length < 0 {
+		return "", fmt.Errorf("%w: negative length", GenerateOptionsErr)
+	}
+	if length > MaxGenerateLength {
+		return "", fmt.Errorf("%w: length %v exceeds %v", GenerateOptionsErr, length, MaxGenerateLength)
+	}
+
+	classes := make([]string, 0)
+	if options.Lowercase {
+		classes = append(classes, LowercaseCharset)
+	}
+	if options.Uppercase {
+		classes = append(classes, UppercaseCharset)
+	}
+	if options.Digits {
+		classes = append(classes, DigitCharset)
+	}
+	if options.Symbols {
+		classes = append(classes, SymbolCharset)
+	}
+	if options.Charset != "" {
+		classes = append(classes, options.Charset)
+	}
+	if len(classes) == 0 {
+		classes = append(classes, LowercaseCharset, UppercaseCharset, DigitCharset)
+	}
+
+	// filter classes and build character pool without duplicates
+	// membership holds a bit for every class that contains a character
+	membership := make(map[rune]uint)
+	pool := make([]rune, 0)
+	for i, class := range classes {
+		empty := true
+		for _, r := range class {
+			if options.ExcludeAmbiguous && strings.ContainsRune(AmbiguousCharset, r) {
+				continue
+			}
+			empty = false
+			if membership[r] == 0 {
+				pool = append(pool, r)
+			}
+			membership[r] |= 1 << i
+		}
+		if empty {
+			return "", fmt.Errorf("%w: empty character class", GenerateOptionsErr)
+		}
+	}
+	if options.RequireEachClass && length < len(classes) {
+		return "", fmt.Errorf("%w: length %v cannot cover %v character classes", GenerateOptionsErr, length, len(classes))
+	}
+
+	all := uint(1)<<len(classes) - 1
+	password := make([]rune, length)
+	for range maxGenerateAttempts {
+		covered := uint(0)
+		for i := range password {
+			n, err := randomInt(len(pool))
+			if err != nil {
+				return "", err
+			}
+			password[i] = pool[n]
+			covered |= membership[pool[n]]
+		}
+
+		if !options.RequireEachClass || covered == all {
+			return string(password), nil
+		}
+	}
+	return "", fmt.Errorf("%w: character classes are too unbalanced to cover each class", GenerateOptionsErr)
+}
+
+// generatePassphrase returns a diceware passphrase from the EFF large word list.
+func generatePassphrase(options GenerateOptions) (string, error) {
+	if options.Words < 0 {
+		return "", fmt.Errorf("%w: negative number of words", GenerateOptionsErr)
+	}
+	if options.Words > MaxGenerateWords {
+		return "", fmt.Errorf("%w: %v words exceed %v", GenerateOptionsErr, options.Words, MaxGenerateWords)
+	}
+
+	separator := options.Separator
+	if separator == "" {
+		separator = DefaultWordSeparator
+	}
+	if len(separator) > maxWordSeparatorLength {
+		return "", fmt.Errorf("%w: separator exceeds %v bytes", GenerateOptionsErr, maxWordSeparatorLength)
+	}
+
+	words := wordlist()
+	passphrase := make([]string, options.Words)
+	for i := range passphrase {
+		n, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		passphrase[i] = words[n]
+	}
+
+	return strings.Join(passphrase, separator), nil
+}
+
+// randomInt returns a uniform random number in [0, n) from crypto/rand.
+func randomInt(n int) (int, error) {
+	r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(r.Int64()), nil
+}
+
+// Generate creates a new random password with Generate and stores it with Overwrite.
+// key is the encryption secret for storage.
+// The password policy applies to the generated password.
+func (m *Manager) Generate(id string, options GenerateOptions, key string) (string, error) {
+	password, err := Generate(options)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.Overwrite(id, password, key)
+	if err != nil {
+		return "", err
+	}
+
+	return password, nil
+}
@@ -0,0 +1,133 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGenerate(t *testing.T) {
+	// a custom class that dwarfs all other classes
+	wide := make([]rune, 0, 0x40000)
+	for r := rune(0x10000); r < 0x50000; r++ {
+		wide = append(wide, r)
+	}
+
+	tests := []struct {
+		name    string
+		options GenerateOptions
+		length  int
+		allowed string
+		classes []string
+		wantErr error
+	}{
+		{"default", GenerateOptions{}, DefaultGenerateLength, LowercaseCharset + UppercaseCharset + DigitCharset, nil, nil},
+		{"digits", GenerateOptions{Length: 8, Digits: true}, 8, DigitCharset, nil, nil},
+		{"custom", GenerateOptions{Length: 5, Charset: "äö"}, 5, "äö", nil, nil},
+		{"no ambiguous", GenerateOptions{Length: 200, Lowercase: true, Uppercase: true, Digits: true, ExcludeAmbiguous: true},
+			200, "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789", nil, nil},
+		{"each class", GenerateOptions{Length: 4, Lowercase: true, Uppercase: true, Digits: true, Symbols: true, RequireEachClass: true},
+			4, LowercaseCharset + UppercaseCharset + DigitCharset + SymbolCharset, []string{LowercaseCharset, UppercaseCharset, DigitCharset, SymbolCharset}, nil},
+		{"too short", GenerateOptions{Length: 1, Digits: true, Symbols: true, RequireEachClass: true}, 0, "", nil, GenerateOptionsErr},
+		{"negative", GenerateOptions{Length: -1}, 0, "", nil, GenerateOptionsErr},
+		{"too long", GenerateOptions{Length: MaxGenerateLength + 1}, 0, "", nil, GenerateOptionsErr},
+		{"unbalanced", GenerateOptions{Length: 5, Lowercase: true, Uppercase: true, Digits: true, Symbols: true, Charset: string(wide), RequireEachClass: true},
+			0, "", nil, GenerateOptionsErr},
+		{"empty class", GenerateOptions{Charset: "0O", ExcludeAmbiguous: true}, 0, "", nil, GenerateOptionsErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				got, err := Generate(tt.options)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if utf8.RuneCountInString(got) != tt.length {
+					t.Errorf("Generate() = %v, want length %v", got, tt.length)
+				}
+				for _, r := range got {
+					if !strings.ContainsRune(tt.allowed, r) {
+						t.Errorf("Generate() = %v, contains %q", got, r)
+					}
+				}
+				for _, class := range tt.classes {
+					if !strings.ContainsAny(got, class) {
+						t.Errorf("Generate() = %v, want character of %v", got, class)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerate_passphrase(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   GenerateOptions
+		separator string
+		wantErr   error
+	}{
+		{"default separator", GenerateOptions{Words: 6}, DefaultWordSeparator, nil},
+		{"custom separator", GenerateOptions{Words: 4, Separator: " "}, " ", nil},
+		{"negative", GenerateOptions{Words: -1}, "", GenerateOptionsErr},
+		{"too many", GenerateOptions{Words: MaxGenerateWords + 1}, "", GenerateOptionsErr},
+		{"long separator", GenerateOptions{Words: 4, Separator: strings.Repeat("-", 17)}, "", GenerateOptionsErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Generate(tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			words := strings.Split(got, tt.separator)
+			if len(words) != tt.options.Words {
+				t.Errorf("Generate() = %v, want %v words", got, tt.options.Words)
+			}
+			for _, w := range words {
+				if w == "" || strings.Trim(w, LowercaseCharset+"-") != "" {
+					t.Errorf("Generate() = %v, invalid word %q", got, w)
+				}
+			}
+		})
+	}
+
+	if n := len(wordlist()); n != 7776 {
+		t.Errorf("len(wordlist()) = %v, want 7776", n)
+	}
+}
+
+func TestManager_Generate(t *testing.T) {
+	// init
+	oldHash := Hash
+	Hash = sha256Hash
+	m := NewManagerWithStorage(NewTemporaryStorage())
+
+	// test
+	generated, err := m.Generate("foo", GenerateOptions{Length: 12}, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != generated {
+		t.Errorf("Get() = %v, want %v", got, generated)
+	}
+
+	m.SetPasswordPolicy(RulePolicy{MinLength: 16})
+	_, err = m.Generate("foo", GenerateOptions{Length: 12}, "456")
+	if !errors.Is(err, PolicyViolationErr) {
+		t.Errorf("Generate() error = %v, want %v", err, PolicyViolationErr)
+	}
+
+	// cleanup
+	Hash = oldHash
+}
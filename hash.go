@@ -0,0 +1,190 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/image357/password/log"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// phcPrefix is the prefix of argon2id hashes in PHC string format.
+const phcPrefix = "$argon2id$"
+
+const phcSaltLength = 16
+const phcHashLength = 32
+
+// Argon2ParamsErr is returned by SetHashParams and SetKDFParams for unreasonable parameters.
+var Argon2ParamsErr = errors.New("invalid argon2 parameters")
+
+// Argon2Params are the cost parameters of argon2id password hashes.
+// Time and Threads are limited to 16 and Memory to 256 MiB, since stored parameters are only authenticated after hashing.
+type Argon2Params struct {
+	// Time is the number of passes.
+	Time uint32
+
+	// Memory is the memory in KiB.
+	Memory uint32
+
+	// Threads is the parallelism.
+	Threads uint8
+}
+
+// DefaultArgon2Params are the cost parameters of password hashes if a Manager does not set its own (see Manager.SetHashParams).
+var DefaultArgon2Params = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// below reports whether any cost parameter of p is lower than the corresponding parameter of other.
+func (p Argon2Params) below(other Argon2Params) bool {
+	return p.Time < other.Time || p.Memory < other.Memory || p.Threads < other.Threads
+}
+
+// validate returns Argon2ParamsErr for unreasonable parameters.
+func (p Argon2Params) validate() error {
+	if p.Time == 0 || p.Time > maxKDFTime || p.Threads == 0 || p.Threads > maxKDFThreads || p.Memory == 0 || p.Memory > maxKDFMemory {
+		return fmt.Errorf("%w: time %v, memory %v, threads %v", Argon2ParamsErr, p.Time, p.Memory, p.Threads)
+	}
+	return nil
+}
+
+// hashArgon2id returns the argon2id hash of password with a random salt in PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, phcSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, phcHashLength)
+
+	return fmt.Sprintf("%vv=%v$m=%v,t=%v,p=%v$%v$%v",
+		phcPrefix, argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// parseArgon2id decodes an argon2id hash in PHC string format.
+func parseArgon2id(hashedPassword string) (Argon2Params, []byte, []byte, error) {
+	fields := strings.Split(strings.TrimPrefix(hashedPassword, phcPrefix), "$")
+	if len(fields) != 4 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: invalid PHC string", CorruptEntryErr)
+	}
+
+	var version int
+	_, err := fmt.Sscanf(fields[0], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: unsupported argon2 version", CorruptEntryErr)
+	}
+
+	var params Argon2Params
+	_, err = fmt.Sscanf(fields[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	err = params.validate()
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: %v", CorruptEntryErr, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	if len(hash) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: empty hash", CorruptEntryErr)
+	}
+
+	return params, salt, hash, nil
+}
+
+// compareArgon2id compares an argon2id hash in PHC string format against a password.
+func compareArgon2id(hashedPassword string, password string) (bool, error) {
+	params, salt, hash, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(hash)))
+
+	return subtle.ConstantTimeCompare(hash, other) == 1, nil
+}
+
+// needsRehash reports whether a stored hash is a legacy hash or uses weaker parameters than params.
+func needsRehash(hashedPassword string, params Argon2Params) bool {
+	if !strings.HasPrefix(hashedPassword, phcPrefix) {
+		return true
+	}
+
+	current, _, _, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return false
+	}
+	return current.below(params)
+}
+
+// needsRehash reports whether Check should replace the stored hash of an entry.
+// Imported foreign hashes are only replaced if EnableHashUpgrade was called.
+func (m *Manager) needsRehash(entry Entry) bool {
+	if !m.isHashed(entry.Id) {
+		return false
+	}
+	if isForeignHash(entry.Password) {
+		return m.hashUpgrade
+	}
+	return needsRehash(entry.Password, m.GetHashParams())
+}
+
+// SetHashParams sets the argon2id cost parameters of new password hashes (see Manager.HashPassword).
+// Check transparently rehashes passwords with weaker parameters or legacy hashes.
+func (m *Manager) SetHashParams(params Argon2Params) error {
+	err := params.validate()
+	if err != nil {
+		return err
+	}
+	m.hashParams = params
+	return nil
+}
+
+// GetHashParams returns the argon2id cost parameters of new password hashes.
+func (m *Manager) GetHashParams() Argon2Params {
+	if m.hashParams == (Argon2Params{}) {
+		return DefaultArgon2Params
+	}
+	return m.hashParams
+}
+
+// rehash replaces the stored hash of a normalized id, if it still equals the hash of entry.
+// The password must already be verified. Timestamps, versions and recovery entries are unchanged.
+func (m *Manager) rehash(entry Entry, password string, key string) {
+	m.lockId(entry.Id)
+	defer m.unlockId(entry.Id)
+
+	storedData, err := m.storageBackend.Retrieve(entry.Id)
+	if err != nil {
+		return
+	}
+	current, err := m.decryptEntry(entry.Id, storedData, key)
+	if err != nil || current.Password != entry.Password {
+		return
+	}
+
+	hashedPassword, err := hashArgon2id(password, m.GetHashParams())
+	if err != nil {
+		log.Warn("cannot rehash password", "id", entry.Id, "error", err)
+		return
+	}
+	current.Password = hashedPassword
+
+	err = m.storeEntry(current, key, storedData)
+	if err != nil {
+		log.Warn("cannot rehash password", "id", entry.Id, "error", err)
+	}
+}
@@ -0,0 +1,221 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// legacyHashedPassword returns a hashed password in the legacy format of salt + Hash with base64 encoding.
+func legacyHashedPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	hash := Hash([]byte(password), salt)
+	return base64.StdEncoding.EncodeToString(append(salt, hash[:]...)), nil
+}
+
+func Test_hashArgon2id(t *testing.T) {
+	hashedPassword, err := hashArgon2id("foo", Argon2Params{2, 128, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=128,t=2,p=1$") {
+		t.Errorf("hashArgon2id() = %v, want PHC string", hashedPassword)
+	}
+
+	params, salt, hash, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != (Argon2Params{2, 128, 1}) || len(salt) != phcSaltLength || len(hash) != phcHashLength {
+		t.Errorf("parseArgon2id() = %v, %v, %v", params, salt, hash)
+	}
+
+	invalid := []string{
+		"$argon2id$",
+		"$argon2id$v=16$m=128,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=128,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=128,t=2,p=1$!$aGFzaA",
+		"$argon2id$v=19$m=128,t=2,p=1$c2FsdA$",
+	}
+	for _, c := range invalid {
+		_, err = compareHashedPassword(c, "foo")
+		if !errors.Is(err, CorruptEntryErr) {
+			t.Errorf("compareHashedPassword(%v) error = %v, want %v", c, err, CorruptEntryErr)
+		}
+	}
+}
+
+func Test_needsRehash(t *testing.T) {
+	params := Argon2Params{2, 128, 2}
+	legacy, err := legacyHashedPassword("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		params Argon2Params
+		legacy bool
+		want   bool
+	}{
+		{"legacy", params, true, true},
+		{"equal", params, false, false},
+		{"stronger", Argon2Params{3, 256, 2}, false, false},
+		{"weaker time", Argon2Params{1, 128, 2}, false, true},
+		{"weaker memory", Argon2Params{2, 64, 2}, false, true},
+		{"weaker threads", Argon2Params{2, 128, 1}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashedPassword := legacy
+			if !tt.legacy {
+				hashedPassword, err = hashArgon2id("foo", tt.params)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := needsRehash(hashedPassword, params); got != tt.want {
+				t.Errorf("needsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_Check_rehash(t *testing.T) {
+	// init test
+	oldHash := Hash
+	Hash = sha256Hash
+	defer func() { Hash = oldHash }()
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.HashPassword = true
+
+	err := m.SetHashParams(Argon2Params{})
+	if !errors.Is(err, Argon2ParamsErr) || errors.Is(err, CorruptEntryErr) {
+		t.Errorf("SetHashParams() error = %v, want %v", err, Argon2ParamsErr)
+	}
+	if m.GetHashParams() != DefaultArgon2Params {
+		t.Errorf("GetHashParams() = %v, want %v", m.GetHashParams(), DefaultArgon2Params)
+	}
+
+	// legacy hash
+	legacy, err := legacyHashedPassword("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.storeEntry(Entry{Id: "foo", Password: legacy}, "456", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wrong passwords do not rehash
+	err = m.SetHashParams(Argon2Params{1, 64, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := m.Check("foo", "789", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("Check() = %v, want false", ok)
+	}
+	entry, err := m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != legacy {
+		t.Errorf("Check() rehashed a wrong password")
+	}
+
+	// legacy hashes and weaker parameters are rehashed
+	for _, params := range []Argon2Params{{1, 64, 1}, {2, 64, 1}} {
+		err = m.SetHashParams(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err = m.Check("foo", "123", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("Check() = %v, want true", ok)
+		}
+		entry, err = m.GetEntry("foo", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _, _, err := parseArgon2id(entry.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != params {
+			t.Errorf("Check() stored params = %v, want %v", stored, params)
+		}
+	}
+
+	// stronger parameters are kept
+	err = m.SetHashParams(Argon2Params{1, 64, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := entry.Password
+	ok, err = m.Check("foo", "123", "456")
+	if err != nil || !ok {
+		t.Fatalf("Check() = %v, %v", ok, err)
+	}
+	entry, err = m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != before {
+		t.Errorf("Check() rehashed stronger parameters")
+	}
+}
+
+func TestManager_Unset_rehash(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.HashPassword = true
+	err := m.SetHashParams(Argon2Params{1, 64, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetHashParams(Argon2Params{2, 64, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an entry that needs a rehash must not lock its id twice
+	done := make(chan error)
+	go func() { done <- m.Unset("foo", "123", "456") }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Unset() deadlocked")
+	}
+
+	exists, err := m.Exists("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("Unset() kept the entry")
+	}
+}
@@ -0,0 +1,250 @@
+package password
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"reflect"
+	"strings"
+)
+
+// headerPrefix marks ciphertexts of Encrypt with a versioned header.
+// The colon is not part of the base64 alphabet, i.e. legacy ciphertexts never carry this prefix.
+const headerPrefix = "pwd:"
+
+// HeaderVersion is the current version of the ciphertext header.
+const HeaderVersion uint8 = 1
+
+// headerLength is the length of an encoded version 1 header.
+const headerLength = 12
+
+// Upper bounds of argon2 parameters, since headers and password hashes are only authenticated after key derivation.
+// A tampered entry must not make Get or Check hang or run out of memory before it is rejected.
+const (
+	// maxKDFTime limits the number of argon2 passes.
+	maxKDFTime uint32 = 16
+
+	// maxKDFMemory limits the argon2 memory in KiB.
+	maxKDFMemory uint32 = 256 * 1024
+
+	// maxKDFThreads limits the argon2 parallelism.
+	maxKDFThreads uint8 = 16
+)
+
+// CipherId identifies the authenticated encryption algorithm of a ciphertext.
+type CipherId uint8
+
+// Supported ciphers.
+const (
+	// CipherAES256GCM is AES-256 in Galois Counter Mode with a 12 byte random nonce.
+	CipherAES256GCM CipherId = 1
+
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305 with a 24 byte random nonce.
+	// It is fast without AES hardware support and safe with random nonces for large numbers of entries.
+	CipherXChaCha20Poly1305 CipherId = 2
+)
+
+// UnsupportedCipherErr is wrapped if a cipher id is unknown.
+var UnsupportedCipherErr = errors.New("unsupported cipher")
+
+// KDFId identifies the key derivation function that turns a secret into an encryption key.
+type KDFId uint8
+
+// Supported key derivation functions.
+const (
+	// KDFCustom uses the global Hash function, which was overwritten by the user.
+	KDFCustom KDFId = 0
+
+	// KDFArgon2i uses argon2.Key with the stored parameters.
+	KDFArgon2i KDFId = 1
+)
+
+// KDFParams describes a key derivation function and its parameters.
+type KDFParams struct {
+	// Id is the key derivation function.
+	Id KDFId
+
+	// Time is the number of argon2 passes.
+	Time uint32
+
+	// Memory is the argon2 memory in KiB.
+	Memory uint32
+
+	// Threads is the argon2 parallelism.
+	Threads uint8
+}
+
+// Header describes a ciphertext of Encrypt.
+type Header struct {
+	// Version is the header version. Legacy ciphertexts without a header have version 0.
+	Version uint8
+
+	// Cipher is the authenticated encryption algorithm.
+	Cipher CipherId
+
+	// KDF is the key derivation function of the secret.
+	KDF KDFParams
+}
+
+// ParseHeader returns the header of a ciphertext of Encrypt.
+// Legacy ciphertexts without a header return the zero Header, i.e. version 0 with the global Hash function.
+func ParseHeader(ciphertext string) (Header, error) {
+	header, _, err := splitHeader(ciphertext)
+	return header, err
+}
+
+// IsLegacy reports whether the header describes a legacy ciphertext without a header.
+func (h Header) IsLegacy() bool {
+	return h.Version == 0
+}
+
+// valid reports whether the cipher id is supported.
+func (c CipherId) valid() bool {
+	return c == CipherAES256GCM || c == CipherXChaCha20Poly1305
+}
+
+// currentHeader returns the header that Encrypt writes with the current configuration.
+func currentHeader() Header {
+	return Header{HeaderVersion, CipherAES256GCM, currentKDF()}
+}
+
+// currentKDF returns DefaultKDFParams, if the global Hash function was not overwritten.
+// Custom Hash functions cannot be described, i.e. they are recorded as KDFCustom.
+func currentKDF() KDFParams {
+	if reflect.ValueOf(Hash).Pointer() == reflect.ValueOf(argon2iHash).Pointer() {
+		return DefaultKDFParams.kdf()
+	}
+	return KDFParams{Id: KDFCustom}
+}
+
+// argon2Key derives the storage keys of KDFArgon2i. It is a variable so that tests can count derivations.
+var argon2Key = argon2.Key
+
+// deriveKey derives a 32 byte encryption key from secret and salt. hash is used for KDFCustom.
+func (p KDFParams) deriveKey(secret []byte, salt []byte, hash HashFunc) [32]byte {
+	switch p.Id {
+	case KDFArgon2i:
+		return [32]byte(argon2Key(secret, salt, p.Time, p.Memory, p.Threads, 32))
+	default:
+		return hash(secret, salt)
+	}
+}
+
+// validate returns CorruptEntryErr for unknown or unreasonable parameters.
+func (p KDFParams) validate() error {
+	switch p.Id {
+	case KDFCustom:
+		return nil
+	case KDFArgon2i:
+		err := Argon2Params{p.Time, p.Memory, p.Threads}.validate()
+		if err != nil {
+			return fmt.Errorf("%w: %v", CorruptEntryErr, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown key derivation function %v", CorruptEntryErr, p.Id)
+	}
+}
+
+// marshal encodes a version 1 header.
+func (h Header) marshal() []byte {
+	data := make([]byte, headerLength)
+	data[0] = h.Version
+	data[1] = byte(h.Cipher)
+	data[2] = byte(h.KDF.Id)
+	binary.BigEndian.PutUint32(data[3:7], h.KDF.Time)
+	binary.BigEndian.PutUint32(data[7:11], h.KDF.Memory)
+	data[11] = h.KDF.Threads
+	return data
+}
+
+// unmarshalHeader decodes and validates a version 1 header.
+func unmarshalHeader(data []byte) (Header, error) {
+	if len(data) != headerLength {
+		return Header{}, fmt.Errorf("%w: invalid header length", CorruptEntryErr)
+	}
+
+	header := Header{
+		Version: data[0],
+		Cipher:  CipherId(data[1]),
+		KDF: KDFParams{
+			Id:      KDFId(data[2]),
+			Time:    binary.BigEndian.Uint32(data[3:7]),
+			Memory:  binary.BigEndian.Uint32(data[7:11]),
+			Threads: data[11],
+		},
+	}
+	if header.Version != HeaderVersion {
+		return Header{}, fmt.Errorf("%w: unknown header version %v", CorruptEntryErr, header.Version)
+	}
+	if !header.Cipher.valid() {
+		return Header{}, fmt.Errorf("%w: unknown cipher %v", CorruptEntryErr, header.Cipher)
+	}
+
+	return header, header.KDF.validate()
+}
+
+// splitHeader returns the header and the base64 payload of a ciphertext.
+func splitHeader(ciphertext string) (Header, string, error) {
+	payload, found := strings.CutPrefix(ciphertext, headerPrefix)
+	if !found {
+		return Header{}, ciphertext, nil
+	}
+
+	encodedHeader, payload, found := strings.Cut(payload, ":")
+	if !found {
+		return Header{}, "", fmt.Errorf("%w: missing header separator", CorruptEntryErr)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encodedHeader)
+	if err != nil {
+		return Header{}, "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+
+	header, err := unmarshalHeader(data)
+	if err != nil {
+		return Header{}, "", err
+	}
+
+	return header, payload, nil
+}
+
+// prefix returns the string prefix of a ciphertext with header h, which is also authenticated as additional data.
+func (h Header) prefix() string {
+	return headerPrefix + base64.StdEncoding.EncodeToString(h.marshal()) + ":"
+}
+
+// SetCipher selects the authenticated cipher of new entries. Existing entries stay readable, since every ciphertext records its cipher.
+// Use Migrate to re-encrypt existing entries with the new cipher.
+func (m *Manager) SetCipher(id CipherId) error {
+	if !id.valid() {
+		return fmt.Errorf("%w: %v", UnsupportedCipherErr, id)
+	}
+	m.cipher = id
+	return nil
+}
+
+// GetCipher returns the authenticated cipher of new entries.
+func (m *Manager) GetCipher() CipherId {
+	if m.cipher == 0 {
+		return CipherAES256GCM
+	}
+	return m.cipher
+}
+
+// header returns the ciphertext header of new entries of the manager.
+func (m *Manager) header() Header {
+	return Header{HeaderVersion, m.GetCipher(), m.GetKDF()}
+}
+
+// encryptWithKey encrypts text with a key derived from the storage key and the cipher of the manager.
+func (m *Manager) encryptWithKey(text string, key string) (string, error) {
+	return encryptWithHeader(text, key, m.header(), m.getHash(), &m.keyCache)
+}
+
+// decryptWithKey decrypts a ciphertext of encryptWithKey or Encrypt with the custom hash function of the manager.
+func (m *Manager) decryptWithKey(ciphertext string, key string) (string, error) {
+	return decryptWithHash(ciphertext, key, m.getHash(), &m.keyCache)
+}
@@ -0,0 +1,484 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// legacyEncrypt returns a ciphertext in the format of Encrypt without header.
+func legacyEncrypt(text string, secret string) (string, error) {
+	salt := make([]byte, saltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := Hash([]byte(secret), salt)
+	cipherBytes, err := sealAEAD(CipherAES256GCM, key[:], []byte(text), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(append(salt, cipherBytes...)), nil
+}
+
+func TestParseHeader(t *testing.T) {
+	oldHash := Hash
+	defer func() { Hash = oldHash }()
+
+	ciphertext, err := Encrypt("foo", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ParseHeader(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, argon2iTime, argon2iMemory * 1024, argon2iThreads}}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("ParseHeader() = %v, want %v", header, want)
+	}
+
+	Hash = sha256Hash
+	ciphertext, err = Encrypt("foo", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err = ParseHeader(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.KDF.Id != KDFCustom {
+		t.Errorf("ParseHeader() kdf = %v, want %v", header.KDF.Id, KDFCustom)
+	}
+
+	legacy, err := legacyEncrypt("foo", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err = ParseHeader(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.IsLegacy() {
+		t.Errorf("ParseHeader() = %v, want legacy", header)
+	}
+
+	invalid := []string{
+		headerPrefix + "missing separator",
+		headerPrefix + "not base64:",
+		headerPrefix + base64.StdEncoding.EncodeToString([]byte{1, 2, 3}) + ":",
+		Header{2, CipherAES256GCM, KDFParams{Id: KDFCustom}}.prefix(),
+		Header{HeaderVersion, 99, KDFParams{Id: KDFCustom}}.prefix(),
+		Header{HeaderVersion, CipherAES256GCM, KDFParams{Id: 99}}.prefix(),
+		Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, 0, 1024, 1}}.prefix(),
+		Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, 0xFFFFFFFF, 1024, 1}}.prefix(),
+		Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, 1, 4 * 1024 * 1024, 1}}.prefix(),
+		Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, 1, 1024, 255}}.prefix(),
+	}
+	for _, c := range invalid {
+		_, err = ParseHeader(c)
+		if !errors.Is(err, CorruptEntryErr) {
+			t.Errorf("ParseHeader(%v) error = %v, want %v", c, err, CorruptEntryErr)
+		}
+	}
+}
+
+func TestDecrypt_header(t *testing.T) {
+	oldHash := Hash
+	defer func() { Hash = oldHash }()
+
+	// stored parameters are used regardless of Hash
+	header := Header{HeaderVersion, CipherAES256GCM, KDFParams{KDFArgon2i, 1, 64, 1}}
+	ciphertext, err := encryptWithHeader("foo", "123", header, Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Hash = sha256Hash
+	got, err := Decrypt(ciphertext, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "foo" {
+		t.Errorf("Decrypt() = %v, want foo", got)
+	}
+
+	// legacy ciphertexts use Hash
+	legacy, err := legacyEncrypt("bar", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = Decrypt(legacy, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "bar" {
+		t.Errorf("Decrypt() = %v, want bar", got)
+	}
+
+	// header is authenticated
+	custom, err := encryptWithHeader("foo", "123", Header{HeaderVersion, CipherAES256GCM, KDFParams{Id: KDFCustom}}, Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, found := strings.Cut(strings.TrimPrefix(custom, headerPrefix), ":")
+	if !found {
+		t.Fatal("missing header separator")
+	}
+	tampered := header.prefix() + payload
+	_, err = Decrypt(tampered, "123")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("Decrypt() error = %v, want %v", err, AuthenticationErr)
+	}
+
+	// expensive parameters are rejected before key derivation
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	err = m.Overwrite("foo", "bar", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := m.storageBackend.Retrieve("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, found = strings.Cut(strings.TrimPrefix(stored, headerPrefix), ":")
+	if !found {
+		t.Fatal("missing header separator")
+	}
+	for _, kdf := range []KDFParams{{KDFArgon2i, 0xFFFFFFFF, 64, 1}, {KDFArgon2i, 1, 4 * 1024 * 1024, 1}} {
+		err = m.storageBackend.Store("foo", Header{HeaderVersion, CipherAES256GCM, kdf}.prefix()+payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.Get("foo", "123")
+		if !errors.Is(err, CorruptEntryErr) {
+			t.Errorf("Get() error = %v, want %v", err, CorruptEntryErr)
+		}
+		_, err = m.Check("foo", "bar", "123")
+		if !errors.Is(err, CorruptEntryErr) {
+			t.Errorf("Check() error = %v, want %v", err, CorruptEntryErr)
+		}
+	}
+}
+
+func TestManager_Migrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Storage
+	}{
+		{"FileStorage", NewFileStorage()},
+		{"TemporaryStorage", NewTemporaryStorage()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(tt.backend)
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_Migrate")
+			}
+
+			// legacy entries
+			m.EnableRecovery("rec")
+			m.EnableHistory(1)
+			for _, id := range []string{"foo", "bar"} {
+				for _, p := range []string{"1", "2"} {
+					err := m.Overwrite(id, id+p, "456")
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			ids, err := m.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range ids {
+				data, err := tt.backend.Retrieve(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				key := "456"
+				if strings.HasSuffix(id, RecoveryIdSuffix) {
+					key = "rec"
+				}
+				packedData, err := Decrypt(data, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				legacy, err := legacyEncrypt(packedData, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = tt.backend.Store(id, legacy)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// migrate all entries
+			migrated, err := m.Migrate(func(string) string { return "456" })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrated) != len(ids) {
+				t.Errorf("Migrate() = %v, want %v", migrated, ids)
+			}
+			for _, id := range ids {
+				data, err := tt.backend.Retrieve(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				header, err := ParseHeader(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header != currentHeader() {
+					t.Errorf("ParseHeader(%v) = %v, want %v", id, header, currentHeader())
+				}
+			}
+			got, err := m.Get("foo", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "foo2" {
+				t.Errorf("Get() = %v, want foo2", got)
+			}
+
+			// current entries are not migrated again
+			migrated, err = m.Migrate(func(string) string { return "456" })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrated) != 0 {
+				t.Errorf("Migrate() = %v, want empty", migrated)
+			}
+
+			// cleanup test
+			Hash = oldHash
+			err = m.Clean()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_Migrate_envelope(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableEnvelopeEncryption()
+	for _, id := range []string{"foo", "bar"} {
+		err := m.Overwrite(id, id+"123", "master")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the keyring is migrated with the master key, envelope entries are unchanged
+	err := m.SetCipher(CipherXChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := make([]string, 0)
+	migrated, err := m.Migrate(func(id string) string {
+		requested = append(requested, id)
+		return "master"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(migrated, []string{KeyringId}) {
+		t.Errorf("Migrate() = %v, want %v", migrated, []string{KeyringId})
+	}
+	if !slices.Contains(requested, KeyringId) {
+		t.Errorf("Migrate() requested keys of %v, want %v", requested, KeyringId)
+	}
+	encryptedData, err := m.GetStorage().Retrieve(KeyringId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ParseHeader(encryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Cipher != CipherXChaCha20Poly1305 {
+		t.Errorf("ParseHeader() cipher = %v, want %v", header.Cipher, CipherXChaCha20Poly1305)
+	}
+
+	// entries stay readable with a new manager
+	other := NewManagerWithStorage(m.GetStorage())
+	other.SetHashFunc(sha256Hash)
+	for _, id := range []string{"foo", "bar"} {
+		got, err := other.Get(id, "master")
+		if err != nil || got != id+"123" {
+			t.Errorf("Get() of %v = %v, %v, want %v", id, got, err, id+"123")
+		}
+	}
+
+	// a wrong master key skips the keyring
+	err = m.SetCipher(CipherAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err = m.Migrate(func(id string) string { return "wrong" })
+	if err != nil || len(migrated) != 0 {
+		t.Errorf("Migrate() with wrong key = %v, %v, want no ids", migrated, err)
+	}
+}
+
+func TestManager_Migrate_concurrent(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableRecovery("rec")
+	ids := []string{"foo", "bar", "baz"}
+	for _, id := range ids {
+		err := m.Overwrite(id, "123", id+"-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// migrate while passwords are written and the recovery key is rotated
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				err := m.Overwrite(id, strconv.Itoa(i), id+"-key")
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_, err := m.Migrate(func(id string) string { return id + "-key" })
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			_ = m.RotateRecoveryKey("rec", "new")
+			_ = m.RotateRecoveryKey("new", "rec")
+		}
+	}()
+	wg.Wait()
+
+	for _, id := range ids {
+		got, err := m.Get(id, id+"-key")
+		if err != nil || got != "19" {
+			t.Errorf("Get() of %v = %v, %v, want 19", id, got, err)
+		}
+	}
+}
+
+func TestManager_SetCipher(t *testing.T) {
+	// init test
+	oldHash := Hash
+	Hash = sha256Hash
+	defer func() { Hash = oldHash }()
+	m := NewManagerWithStorage(NewTemporaryStorage())
+
+	err := m.SetCipher(99)
+	if !errors.Is(err, UnsupportedCipherErr) {
+		t.Errorf("SetCipher() error = %v, want %v", err, UnsupportedCipherErr)
+	}
+	if m.GetCipher() != CipherAES256GCM {
+		t.Errorf("GetCipher() = %v, want %v", m.GetCipher(), CipherAES256GCM)
+	}
+
+	// mixed store
+	err = m.Overwrite("aes", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetCipher(CipherXChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("xchacha", "789", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.EnableEnvelopeEncryption()
+	err = m.Overwrite("envelope", "abc", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := m.storageBackend.Retrieve("xchacha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ParseHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Cipher != CipherXChaCha20Poly1305 {
+		t.Errorf("ParseHeader() cipher = %v, want %v", header.Cipher, CipherXChaCha20Poly1305)
+	}
+	data, err = m.storageBackend.Retrieve("envelope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(data, envelopePrefix+"2"+envelopeSeparator) {
+		t.Errorf("Retrieve() = %v, want XChaCha20-Poly1305 envelope", data)
+	}
+
+	m.DisableEnvelopeEncryption()
+	err = m.SetCipher(CipherAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{"aes": "123", "xchacha": "789", "envelope": "abc"} {
+		got, err := m.Get(id, "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Get(%v) = %v, want %v", id, got, want)
+		}
+	}
+
+	// migrate to the selected cipher
+	err = m.SetCipher(CipherXChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := m.Migrate(func(string) string { return "456" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(migrated, []string{"aes"}) {
+		t.Errorf("Migrate() = %v, want [aes]", migrated)
+	}
+}
@@ -0,0 +1,286 @@
+package password
+
+import (
+	"errors"
+	"github.com/image357/password/log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HistoryIdSuffix stores the id/folder suffix that identifies version history entries.
+// Version n of myid is stored with id "myid.history/n".
+const HistoryIdSuffix string = ".history"
+
+// EnableHistory will keep the last size encrypted versions of every password in the storage backend.
+// Versions are recorded whenever a password is replaced by Overwrite, Set or Rollback.
+func (m *Manager) EnableHistory(size int) {
+	m.historySize = max(size, 0)
+}
+
+// DisableHistory will stop recording new versions. Existing versions are kept until the password is deleted.
+func (m *Manager) DisableHistory() {
+	m.historySize = 0
+}
+
+// ListVersions returns the ascending version numbers that are stored for a password.
+// Higher numbers are more recent versions.
+func (m *Manager) ListVersions(id string) ([]int, error) {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.listVersions(id)
+}
+
+// GetVersion returns a previous version of a password together with its metadata.
+// key is the encryption secret the version was stored with.
+func (m *Manager) GetVersion(id string, n int, key string) (Entry, error) {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	encryptedData, err := m.storageBackend.Retrieve(versionId(id, n))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return m.decryptEntry(id, encryptedData, key)
+}
+
+// Rollback restores a previous version of a password.
+// key is the encryption secret the version was stored with and will be the storage key afterward.
+// If enabled, the replaced password is recorded as new version and recovery entries will be recreated.
+func (m *Manager) Rollback(id string, n int, key string) error {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	encryptedData, err := m.storageBackend.Retrieve(versionId(id, n))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.decryptEntry(id, encryptedData, key)
+	if err != nil {
+		return err
+	}
+
+	previousData, err := m.storageBackend.Retrieve(id)
+	if err != nil && !errors.Is(err, NotFoundErr) {
+		return err
+	}
+	hasPrevious := err == nil
+
+	err = m.storageBackend.Store(id, encryptedData)
+	if err != nil {
+		return err
+	}
+
+	if m.historySize > 0 && hasPrevious {
+		m.pushHistory(id, previousData)
+	}
+
+	if m.withRecovery && !strings.HasSuffix(id, RecoveryIdSuffix) {
+		// write recovery key file
+		recoveryId := id + RecoveryIdSuffix
+		err = m.writeRecovery(recoveryId, key)
+		if err != nil {
+			log.Warn("cannot write recovery key file", "id", recoveryId)
+		}
+	}
+
+	return nil
+}
+
+// historyPrefix returns the id prefix of all version entries of a normalized id.
+func historyPrefix(id string) string {
+	return id + HistoryIdSuffix + "/"
+}
+
+// isHistoryId reports whether a normalized id belongs to a version entry, i.e. has the form <owner>.history/<n>
+// with a version number n as written by versionId. Other ids that contain .history/ are regular passwords.
+func isHistoryId(id string) bool {
+	_, found := cutVersion(id)
+	return found
+}
+
+// historyOwner returns the normalized id of the password that a version entry belongs to.
+// Ids of other entries are returned unchanged.
+func historyOwner(id string) string {
+	owner, found := cutVersion(id)
+	if !found {
+		return id
+	}
+	return owner
+}
+
+// cutVersion splits a version entry id into the normalized id of its password and reports whether id is a version entry.
+func cutVersion(id string) (string, bool) {
+	i := strings.LastIndex(id, HistoryIdSuffix+"/")
+	if i <= 0 {
+		return "", false
+	}
+	_, found := parseVersion(id[i+len(HistoryIdSuffix)+1:])
+	return id[:i], found
+}
+
+// parseVersion returns the version number of a version id suffix. Only positive numbers in the format of versionId are accepted.
+func parseVersion(suffix string) (int, bool) {
+	n, err := strconv.Atoi(suffix)
+	if err != nil || n < 1 || strconv.Itoa(n) != suffix {
+		return 0, false
+	}
+	return n, true
+}
+
+// versionId returns the storage id of version n of a normalized id.
+func versionId(id string, n int) string {
+	return historyPrefix(id) + strconv.Itoa(n)
+}
+
+// listVersions returns the ascending version numbers of a normalized id.
+// Storage backends that implement folderStorage only read the version folder.
+func (m *Manager) listVersions(id string) ([]int, error) {
+	var ids []string
+	var err error
+	if s, ok := m.storageBackend.(folderStorage); ok {
+		ids, err = s.ListFolder(id + HistoryIdSuffix)
+	} else {
+		ids, err = m.storageBackend.List()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := historyPrefix(id)
+	versions := make([]int, 0)
+	for _, storedId := range ids {
+		suffix, found := strings.CutPrefix(storedId, prefix)
+		if !found {
+			continue
+		}
+		n, found := parseVersion(suffix)
+		if !found {
+			// ignore nested ids and regular passwords
+			continue
+		}
+		versions = append(versions, n)
+	}
+	slices.Sort(versions)
+
+	return versions, nil
+}
+
+// pushHistory stores the replaced ciphertext of a normalized id as the most recent version.
+// It is called after the new ciphertext was stored, i.e. failed writes never rotate the history.
+// Versions that exceed the history size are removed. Failures are logged, since the new ciphertext is already stored.
+func (m *Manager) pushHistory(id string, encryptedData string) {
+	err := m.recordVersion(id, encryptedData)
+	if err != nil {
+		log.Warn("cannot record password version", "id", id, "error", err)
+	}
+}
+
+// recordVersion stores a ciphertext as the most recent version of a normalized id and removes exceeding versions.
+func (m *Manager) recordVersion(id string, encryptedData string) error {
+	versions, err := m.listVersions(id)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(versions) != 0 {
+		next = versions[len(versions)-1] + 1
+	}
+	err = m.storageBackend.Store(versionId(id, next), encryptedData)
+	if err != nil {
+		return err
+	}
+	versions = append(versions, next)
+
+	for _, n := range versions[:max(len(versions)-m.historySize, 0)] {
+		err = m.storageBackend.Delete(versionId(id, n))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteHistory removes all versions of a normalized id.
+func (m *Manager) deleteHistory(id string) error {
+	versions, err := m.listVersions(id)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error = nil
+	for _, n := range versions {
+		err = m.storageBackend.Delete(versionId(id, n))
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// rewriteHistoryKey changes the storage key of all versions of a normalized id from oldKey to newKey.
+// Versions that were stored with a different key are left unchanged.
+func (m *Manager) rewriteHistoryKey(id string, oldKey string, newKey string) error {
+	versions, err := m.listVersions(id)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range versions {
+		vid := versionId(id, n)
+		encryptedData, err := m.storageBackend.Retrieve(vid)
+		if err != nil {
+			return err
+		}
+
+		// versions with key slots keep their data key
+		if isSlotted(encryptedData) {
+			newData, err := m.rewrapSlot(encryptedData, oldKey, newKey)
+			if err != nil {
+				log.Warn("cannot rewrite key of version", "id", vid)
+				continue
+			}
+			err = m.storageBackend.Store(vid, newData)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		packedData, err := m.decrypt(id, encryptedData, oldKey)
+		if err != nil {
+			log.Warn("cannot rewrite key of version", "id", vid)
+			continue
+		}
+
+		err = m.storeSealed(vid, func() (string, error) {
+			return m.encrypt(id, packedData, newKey)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
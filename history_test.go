@@ -0,0 +1,337 @@
+package password
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestManager_History(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Storage
+	}{
+		{"FileStorage", NewFileStorage()},
+		{"TemporaryStorage", NewTemporaryStorage()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(tt.backend)
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_History")
+			}
+
+			// no history without EnableHistory
+			err := m.Overwrite("foo", "1", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.Overwrite("foo", "2", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions, err := m.ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 0 {
+				t.Errorf("ListVersions() = %v, want empty", versions)
+			}
+
+			// keep last two versions
+			m.EnableHistory(2)
+			for _, p := range []string{"3", "4", "5"} {
+				err = m.Overwrite("foo", p, "456")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = m.Set("Foo", "5", "6", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions, err = m.ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(versions, []int{3, 4}) {
+				t.Fatalf("ListVersions() = %v, want [3 4]", versions)
+			}
+			list, err := m.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(list, []string{"foo"}) {
+				t.Errorf("List() = %v, want [foo]", list)
+			}
+			list, err = tt.backend.(folderStorage).ListFolder("Foo.history")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(list, []string{"foo.history/3", "foo.history/4"}) {
+				t.Errorf("ListFolder() = %v", list)
+			}
+			list, err = tt.backend.(folderStorage).ListFolder("bar.history")
+			if err != nil || len(list) != 0 {
+				t.Errorf("ListFolder() of a missing folder = %v, %v", list, err)
+			}
+
+			// failed writes do not rotate versions
+			m.SetStorage(&failingStorage{tt.backend, "foo"})
+			err = m.Overwrite("foo", "7", "456")
+			if err == nil {
+				t.Fatal("Overwrite() with failing storage succeeded")
+			}
+			m.SetStorage(tt.backend)
+			versions, err = m.ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(versions, []int{3, 4}) {
+				t.Fatalf("ListVersions() after failed write = %v, want [3 4]", versions)
+			}
+
+			versionTests := []struct {
+				n       int
+				key     string
+				want    string
+				wantErr error
+			}{
+				{3, "456", "4", nil},
+				{4, "456", "5", nil},
+				{2, "456", "", NotFoundErr},
+				{4, "wrong", "", AuthenticationErr},
+			}
+			for _, vt := range versionTests {
+				got, err := m.GetVersion("foo", vt.n, vt.key)
+				if !errors.Is(err, vt.wantErr) {
+					t.Errorf("GetVersion(%v) error = %v, wantErr %v", vt.n, err, vt.wantErr)
+					continue
+				}
+				if got.Password != vt.want {
+					t.Errorf("GetVersion(%v) got = %v, want %v", vt.n, got.Password, vt.want)
+				}
+			}
+
+			// rollback records the replaced password
+			err = m.Rollback("foo", 3, "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := m.Get("foo", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "4" {
+				t.Errorf("Get() after Rollback() = %v, want 4", got)
+			}
+			versions, err = m.ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(versions, []int{4, 5}) {
+				t.Errorf("ListVersions() = %v, want [4 5]", versions)
+			}
+			err = m.Rollback("foo", 1, "456")
+			if !errors.Is(err, NotFoundErr) {
+				t.Errorf("Rollback() error = %v, want %v", err, NotFoundErr)
+			}
+
+			// rewrite key includes versions
+			err = m.RewriteKey("foo", "456", "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got2, err := m.GetVersion("foo", 5, "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got2.Password != "6" {
+				t.Errorf("GetVersion() after RewriteKey() = %v, want 6", got2.Password)
+			}
+
+			// delete includes versions
+			err = m.Delete("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err = m.storageBackend.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 0 {
+				t.Errorf("List() after Delete() = %v, want empty", list)
+			}
+
+			// cleanup test
+			Hash = oldHash
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_History_reservedIds(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableHistory(2)
+
+	// version ids cannot be written by hand
+	err := m.Overwrite("team.history/1", "mine", "456")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("Overwrite() error = %v, want %v", err, ReservedIdErr)
+	}
+	for _, p := range []string{"1", "2", "3"} {
+		err = m.Overwrite("team", p, "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// version ids are rejected by every call
+	_, err = m.Get("team.history/1", "456")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("Get() error = %v, want %v", err, ReservedIdErr)
+	}
+	err = m.Delete("Team.History\\1")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("Delete() error = %v, want %v", err, ReservedIdErr)
+	}
+	_, err = m.ListVersions("team.history/1")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("ListVersions() error = %v, want %v", err, ReservedIdErr)
+	}
+	_, err = m.GetVersion("team.history/1", 1, "456")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("GetVersion() error = %v, want %v", err, ReservedIdErr)
+	}
+	err = m.Rollback("team.history/1", 1, "456")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("Rollback() error = %v, want %v", err, ReservedIdErr)
+	}
+
+	// the versions of team are unchanged
+	versions, err := m.ListVersions("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range versions {
+		entry, err := m.GetVersion("team", n, "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.Itoa(i + 1); entry.Password != want {
+			t.Errorf("GetVersion(%v) = %v, want %v", n, entry.Password, want)
+		}
+	}
+}
+
+func TestManager_History_regularIds(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+
+	// pre-existing ids that contain .history/ without a version number
+	for _, id := range []string{"x.history/y", "x.history/01", "x.history/1/y"} {
+		err := m.Overwrite(id, "mine", "456")
+		if err != nil {
+			t.Fatalf("Overwrite(%v) error = %v", id, err)
+		}
+	}
+
+	// they stay usable with history enabled and are no versions of x
+	m.EnableHistory(2)
+	for _, p := range []string{"1", "2"} {
+		err := m.Overwrite("x", p, "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := m.Overwrite("x.history/y", "other", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Get("x.history/y", "456")
+	if err != nil || got != "other" {
+		t.Errorf("Get() = %v, %v, want other", got, err)
+	}
+	versions, err := m.ListVersions("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []int{1}) {
+		t.Errorf("ListVersions() = %v, want [1]", versions)
+	}
+	ids, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"x", "x.history/y", "x.history/01", "x.history/1/y"} {
+		if !slices.Contains(ids, id) {
+			t.Errorf("List() = %v, want %v", ids, id)
+		}
+	}
+
+	// deleting x keeps the other passwords
+	err = m.Delete("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"x.history/y", "x.history/01", "x.history/1/y"} {
+		_, err = m.Get(id, "456")
+		if err != nil {
+			t.Errorf("Get(%v) after Delete() error = %v", id, err)
+		}
+	}
+}
+
+func TestManager_History_emptyFolders(t *testing.T) {
+	// init test
+	backend := NewFileStorage()
+	backend.SetStorePath("./tests/workdir/Manager_History_emptyFolders")
+	m := NewManagerWithStorage(backend)
+	m.SetHashFunc(sha256Hash)
+	m.EnableHistory(1)
+	for _, p := range []string{"1", "2", "3"} {
+		err := m.Overwrite("foo", p, "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	folder := filepath.Join(backend.GetStorePath(), "foo"+HistoryIdSuffix)
+	if _, err := os.Stat(folder); err != nil {
+		t.Fatalf("Stat() version folder error = %v", err)
+	}
+
+	// deleting the password removes its version folder
+	err := m.Delete("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(folder); !errors.Is(err, fs.ErrNotExist) && runtime.GOOS != "windows" {
+		t.Errorf("Stat() version folder error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// cleanup test
+	err = os.RemoveAll(backend.GetStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
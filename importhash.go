@@ -0,0 +1,232 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnsupportedHashErr is wrapped by ImportHash if an encoded hash has an unknown format.
+var UnsupportedHashErr = errors.New("unsupported hash format")
+
+// HashingDisabledErr is wrapped by ImportHash if the manager does not store passwords as hashes (see Manager.HashPassword).
+var HashingDisabledErr = errors.New("password hashing disabled")
+
+// maxScryptMemory limits the memory of imported scrypt hashes in bytes.
+const maxScryptMemory = 1 << 30
+
+// EntryExistsErr is wrapped by ImportHash if id holds a password that has not expired.
+var EntryExistsErr = errors.New("password exists")
+
+// maxBcryptCost limits the cost of imported bcrypt hashes.
+const maxBcryptCost = 16
+
+// maxPBKDF2Rounds limits the rounds of imported PBKDF2 hashes.
+const maxPBKDF2Rounds = 10_000_000
+
+// maxShaCryptRounds limits the rounds of imported sha-crypt hashes.
+const maxShaCryptRounds = 10_000_000
+
+// pbkdf2Hashes maps the PBKDF2 schemes of the passlib format to their hash functions.
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	"$pbkdf2$":        sha1.New,
+	"$pbkdf2-sha256$": sha256.New,
+	"$pbkdf2-sha512$": sha512.New,
+}
+
+// isForeignHash reports whether a hashed password uses one of the foreign formats of ImportHash.
+func isForeignHash(hashedPassword string) bool {
+	_, err := compareForeignHash(hashedPassword, "", false)
+	return !errors.Is(err, UnsupportedHashErr)
+}
+
+// compareForeignHash compares a foreign hash against a password. If verify is false, the hash format is only validated.
+// Supported formats:
+//   - bcrypt: $2a$, $2b$, $2y$
+//   - scrypt: $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+//   - PBKDF2 in passlib format: $pbkdf2$, $pbkdf2-sha256$, $pbkdf2-sha512$ followed by <rounds>$<salt>$<hash>
+//   - sha-crypt: $5$ (sha256-crypt), $6$ (sha512-crypt)
+func compareForeignHash(hashedPassword string, password string, verify bool) (bool, error) {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$2a$") ||
+		strings.HasPrefix(hashedPassword, "$2b$") ||
+		strings.HasPrefix(hashedPassword, "$2y$"):
+		return compareBcrypt(hashedPassword, password, verify)
+	case strings.HasPrefix(hashedPassword, "$scrypt$"):
+		return compareScrypt(hashedPassword, password, verify)
+	case strings.HasPrefix(hashedPassword, "$pbkdf2"):
+		return comparePBKDF2(hashedPassword, password, verify)
+	case strings.HasPrefix(hashedPassword, "$5$") || strings.HasPrefix(hashedPassword, "$6$"):
+		return compareShaCrypt(hashedPassword, password, verify)
+	default:
+		return false, fmt.Errorf("%w: unknown prefix", UnsupportedHashErr)
+	}
+}
+
+// compareBcrypt compares a bcrypt hash against a password.
+func compareBcrypt(hashedPassword string, password string, verify bool) (bool, error) {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	if cost > maxBcryptCost {
+		return false, fmt.Errorf("%w: invalid bcrypt cost", CorruptEntryErr)
+	}
+	if !verify {
+		return false, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	return true, nil
+}
+
+// compareScrypt compares a scrypt hash against a password.
+func compareScrypt(hashedPassword string, password string, verify bool) (bool, error) {
+	fields := strings.Split(strings.TrimPrefix(hashedPassword, "$scrypt$"), "$")
+	if len(fields) != 3 {
+		return false, fmt.Errorf("%w: invalid scrypt hash", CorruptEntryErr)
+	}
+
+	var ln, r, p int
+	_, err := fmt.Sscanf(fields[0], "ln=%d,r=%d,p=%d", &ln, &r, &p)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	// the bounds are compared by division, since products of large parameters overflow
+	if ln <= 0 || ln >= 32 || r <= 0 || p <= 0 || uint64(r) > maxScryptMemory/(uint64(128)<<ln) || p > (1<<30-1)/r {
+		return false, fmt.Errorf("%w: invalid scrypt parameters", CorruptEntryErr)
+	}
+
+	salt, hash, err := decodeSaltAndHash(fields[1], fields[2])
+	if err != nil || !verify {
+		return false, err
+	}
+
+	other, err := scrypt.Key([]byte(password), salt, 1<<ln, r, p, len(hash))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	return subtle.ConstantTimeCompare(hash, other) == 1, nil
+}
+
+// comparePBKDF2 compares a PBKDF2 hash in passlib format against a password.
+func comparePBKDF2(hashedPassword string, password string, verify bool) (bool, error) {
+	scheme, rest, found := strings.Cut(strings.TrimPrefix(hashedPassword, "$"), "$")
+	newHash, ok := pbkdf2Hashes["$"+scheme+"$"]
+	if !found || !ok {
+		return false, fmt.Errorf("%w: %v", UnsupportedHashErr, scheme)
+	}
+
+	fields := strings.Split(rest, "$")
+	if len(fields) != 3 {
+		return false, fmt.Errorf("%w: invalid PBKDF2 hash", CorruptEntryErr)
+	}
+	rounds, err := strconv.Atoi(fields[0])
+	if err != nil || rounds <= 0 || rounds > maxPBKDF2Rounds {
+		return false, fmt.Errorf("%w: invalid PBKDF2 rounds", CorruptEntryErr)
+	}
+
+	salt, hash, err := decodeSaltAndHash(fields[1], fields[2])
+	if err != nil || !verify {
+		return false, err
+	}
+
+	other, err := pbkdf2.Key(newHash, password, salt, rounds, len(hash))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	return subtle.ConstantTimeCompare(hash, other) == 1, nil
+}
+
+// decodeSaltAndHash decodes salt and hash in standard or adapted (passlib) base64 encoding without padding.
+func decodeSaltAndHash(encodedSalt string, encodedHash string) ([]byte, []byte, error) {
+	decode := func(s string) ([]byte, error) {
+		s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+
+	salt, err := decode(encodedSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	hash, err := decode(encodedHash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	if len(hash) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty hash", CorruptEntryErr)
+	}
+
+	return salt, hash, nil
+}
+
+// ImportHash stores a password hash of a foreign system for a new or expired id.
+// The manager must store passwords as hashes (see Manager.HashPassword).
+// Supported formats are bcrypt ($2a$, $2b$, $2y$), scrypt ($scrypt$), PBKDF2 in passlib format
+// ($pbkdf2$, $pbkdf2-sha256$, $pbkdf2-sha512$) and sha-crypt ($5$, $6$). Argon2id hashes in PHC string format are accepted as well.
+// key is the encryption secret for storage and must decrypt an expired entry. Timestamps and labels of expired entries are kept.
+// EntryExistsErr is returned if id holds a password that has not expired.
+// Check verifies passwords against imported hashes, and upgrades them to argon2id if EnableHashUpgrade was called.
+func (m *Manager) ImportHash(id string, encodedHash string, key string) error {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+	if !m.isHashed(id) {
+		return fmt.Errorf("%w: %v", HashingDisabledErr, id)
+	}
+
+	if strings.HasPrefix(encodedHash, phcPrefix) {
+		_, _, _, err = parseArgon2id(encodedHash)
+	} else {
+		_, err = compareForeignHash(encodedHash, "", false)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	// expired entries keep their metadata, since the imported hash replaces the password
+	entry, err := m.getEntry(id, key)
+	switch {
+	case errors.Is(err, NotFoundErr):
+		entry = Entry{Id: id}
+	case err != nil:
+		return err
+	case !entry.Expired():
+		return fmt.Errorf("%w: %v", EntryExistsErr, id)
+	}
+	entry.Expires = time.Time{}
+	return m.commit(entry, encodedHash, key)
+}
+
+// EnableHashUpgrade will replace imported foreign hashes with argon2id hashes after a successful Check.
+func (m *Manager) EnableHashUpgrade() {
+	m.hashUpgrade = true
+}
+
+// DisableHashUpgrade will keep imported foreign hashes after a successful Check.
+func (m *Manager) DisableHashUpgrade() {
+	m.hashUpgrade = false
+}
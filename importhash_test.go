@@ -0,0 +1,192 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_compareForeignHash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		hashedPassword string
+		password       string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    bool
+		wantErr error
+	}{
+		{"bcrypt", args{string(bcryptHash), "password"}, true, nil},
+		{"bcrypt wrong", args{string(bcryptHash), "wrong"}, false, nil},
+		{"bcrypt invalid", args{"$2b$xx$invalid", "password"}, false, CorruptEntryErr},
+		{"bcrypt too expensive", args{"$2a$31" + string(bcryptHash[6:]), "password"}, false, CorruptEntryErr},
+		{"scrypt", args{"$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$5f/Vi+XRWGUNGScbsma6KJ4zLFIke/NJsrvr7lQLAyA", "password"}, true, nil},
+		{"scrypt wrong", args{"$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$5f/Vi+XRWGUNGScbsma6KJ4zLFIke/NJsrvr7lQLAyA", "wrong"}, false, nil},
+		{"scrypt invalid", args{"$scrypt$ln=40,r=8,p=1$c2FsdA$aGFzaA", "password"}, false, CorruptEntryErr},
+		{"scrypt too much memory", args{"$scrypt$ln=20,r=8192,p=1$c2FsdA$aGFzaA", "password"}, false, CorruptEntryErr},
+		{"scrypt overflowing memory", args{"$scrypt$ln=1,r=72057594037927936,p=256$c2FsdA$aGFzaA", "password"}, false, CorruptEntryErr},
+		{"pbkdf2", args{"$pbkdf2$1000$c2FsdHNhbHRzYWx0c2FsdA$2FWw/oC7TQkskizC.81lWlmFAMM", "password"}, true, nil},
+		{"pbkdf2-sha256", args{"$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$8nX7hwFEzIB8aPajJTYK8weHQc5Ngz0pFVAKvSu4jQA", "password"}, true, nil},
+		{"pbkdf2-sha512", args{"$pbkdf2-sha512$1000$c2FsdHNhbHRzYWx0c2FsdA$715rqIr5dXOVPpBhqqsugl037zT5bWJTWYmZtIcK8hBnisKpwfY7kokvwjDrNHqHhF50Pb7MD6HvkJwiDQw4ww", "password"}, true, nil},
+		{"pbkdf2 wrong", args{"$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$8nX7hwFEzIB8aPajJTYK8weHQc5Ngz0pFVAKvSu4jQA", "wrong"}, false, nil},
+		{"pbkdf2 invalid", args{"$pbkdf2-sha256$x$c2FsdA$aGFzaA", "password"}, false, CorruptEntryErr},
+		{"pbkdf2 too many rounds", args{"$pbkdf2-sha256$10000001$c2FsdA$aGFzaA", "password"}, false, CorruptEntryErr},
+		{"pbkdf2 unknown", args{"$pbkdf2-md5$1000$c2FsdA$aGFzaA", "password"}, false, UnsupportedHashErr},
+		{"sha256-crypt", args{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"}, true, nil},
+		{"sha512-crypt", args{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"}, true, nil},
+		{"sha512-crypt rounds", args{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!"}, true, nil},
+		{"sha512-crypt wrong", args{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "wrong"}, false, nil},
+		{"sha512-crypt invalid", args{"$6$rounds=x$salt$hash", "password"}, false, CorruptEntryErr},
+		{"sha512-crypt too many rounds", args{"$6$rounds=999999999$salt$hash", "password"}, false, CorruptEntryErr},
+		{"unknown", args{"$1$salt$hash", "password"}, false, UnsupportedHashErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compareForeignHash(tt.args.hashedPassword, tt.args.password, true)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("compareForeignHash() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("compareForeignHash() got = %v, want %v", got, tt.want)
+			}
+
+			// invalid parameters are rejected without verification
+			_, err = compareForeignHash(tt.args.hashedPassword, "", false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("compareForeignHash() without verification error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ImportHash(t *testing.T) {
+	// init test
+	oldHash := Hash
+	Hash = sha256Hash
+	defer func() { Hash = oldHash }()
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	sha512Crypt := "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+
+	err := m.ImportHash("foo", sha512Crypt, "456")
+	if !errors.Is(err, HashingDisabledErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, HashingDisabledErr)
+	}
+
+	m.HashPassword = true
+	err = m.SetHashParams(Argon2Params{1, 64, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ImportHash("foo", "plain", "456")
+	if !errors.Is(err, UnsupportedHashErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, UnsupportedHashErr)
+	}
+	err = m.ImportHash("foo", "$2a$31$"+strings.Repeat("a", 53), "456")
+	if !errors.Is(err, CorruptEntryErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, CorruptEntryErr)
+	}
+	err = m.ImportHash(KeyringId, sha512Crypt, "456")
+	if !errors.Is(err, ReservedIdErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, ReservedIdErr)
+	}
+
+	// imported hashes are kept without upgrade
+	err = m.ImportHash("foo", sha512Crypt, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := m.Check("foo", "wrong", "456")
+	if err != nil || ok {
+		t.Errorf("Check() = %v, %v, want false", ok, err)
+	}
+	ok, err = m.Check("foo", "Hello world!", "456")
+	if err != nil || !ok {
+		t.Errorf("Check() = %v, %v, want true", ok, err)
+	}
+	entry, err := m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != sha512Crypt {
+		t.Errorf("Check() replaced imported hash without upgrade")
+	}
+
+	// upgrade after successful check
+	m.EnableHashUpgrade()
+	ok, err = m.Check("foo", "Hello world!", "456")
+	if err != nil || !ok {
+		t.Errorf("Check() = %v, %v, want true", ok, err)
+	}
+	entry, err = m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(entry.Password, phcPrefix) {
+		t.Errorf("Check() stored %v, want argon2id hash", entry.Password)
+	}
+	ok, err = m.Check("foo", "Hello world!", "456")
+	if err != nil || !ok {
+		t.Errorf("Check() = %v, %v, want true", ok, err)
+	}
+
+	// entries with a different key are not replaced
+	err = m.ImportHash("foo", sha512Crypt, "wrong")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, AuthenticationErr)
+	}
+	ok, err = m.Check("foo", "Hello world!", "456")
+	if err != nil || !ok {
+		t.Errorf("Check() after failed ImportHash() = %v, %v, want true", ok, err)
+	}
+
+	// live entries are not replaced
+	err = m.ImportHash("foo", sha512Crypt, "456")
+	if !errors.Is(err, EntryExistsErr) {
+		t.Errorf("ImportHash() error = %v, want %v", err, EntryExistsErr)
+	}
+	ok, err = m.Check("foo", "Hello world!", "456")
+	if err != nil || !ok {
+		t.Errorf("Check() after failed ImportHash() = %v, %v, want true", ok, err)
+	}
+	entry, err = m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(entry.Password, phcPrefix) {
+		t.Errorf("ImportHash() replaced live entry with %v", entry.Password)
+	}
+
+	// expired entries keep their labels
+	err = m.Overwrite("bar", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetLabels("bar", map[string]string{"team": "ops"}, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OverwriteWithExpiry("bar", "123", "456", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ImportHash("bar", sha512Crypt, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = m.GetEntry("bar", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Expires.IsZero() || entry.Labels["team"] != "ops" {
+		t.Errorf("ImportHash() stored expiry %v and labels %v", entry.Expires, entry.Labels)
+	}
+}
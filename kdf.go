@@ -0,0 +1,109 @@
+package password
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"time"
+)
+
+// CalibrationErr is wrapped by CalibrateArgon2 if the arguments cannot produce parameters.
+var CalibrationErr = errors.New("cannot calibrate argon2")
+
+// DefaultKDFParams are the argon2i parameters of storage key derivation if neither the global Hash function
+// was overwritten nor a Manager sets its own (see Manager.SetKDFParams).
+// Ciphertexts record their parameters, i.e. changing them does not affect existing entries.
+var DefaultKDFParams = Argon2Params{Time: argon2iTime, Memory: argon2iMemory * 1024, Threads: argon2iThreads}
+
+// kdf returns the argon2i key derivation function with parameters p.
+func (p Argon2Params) kdf() KDFParams {
+	return KDFParams{KDFArgon2i, p.Time, p.Memory, p.Threads}
+}
+
+// SetKDFParams sets the argon2i parameters of storage key derivation for new entries.
+// Existing entries stay readable, since every ciphertext records its parameters. Use Migrate to upgrade them.
+func (m *Manager) SetKDFParams(params Argon2Params) error {
+	err := params.validate()
+	if err != nil {
+		return err
+	}
+	m.kdf = params.kdf()
+	return nil
+}
+
+// SetHashFunc sets a custom hash function of the manager, which replaces the global Hash function.
+// It derives storage keys of new entries (recorded as KDFCustom) and is used for legacy entries and legacy password hashes.
+// nil restores the global Hash function. Keys of custom hash functions are not cached (see Manager.EnableKeyCache).
+func (m *Manager) SetHashFunc(hash HashFunc) {
+	m.hashFunc = hash
+}
+
+// GetKDF returns the key derivation function of storage keys for new entries.
+func (m *Manager) GetKDF() KDFParams {
+	if m.hashFunc != nil {
+		return KDFParams{Id: KDFCustom}
+	}
+	if m.kdf != (KDFParams{}) {
+		return m.kdf
+	}
+	return currentKDF()
+}
+
+// getHash returns the custom hash function of the manager or the global Hash function.
+func (m *Manager) getHash() HashFunc {
+	if m.hashFunc != nil {
+		return m.hashFunc
+	}
+	return Hash
+}
+
+// CalibrateArgon2 benchmarks argon2id password hashing on the current machine and returns parameters that take about
+// target per hash. memory is the maximum memory in KiB and threads the parallelism. If a single pass exceeds target,
+// memory is halved until it fits or reaches its minimum. Otherwise, the number of passes is raised to fill target,
+// but at most to 16. The result is meant for Manager.SetHashParams and DefaultArgon2Params.
+// Use CalibrateKDF for the storage key derivation of Manager.SetKDFParams.
+func CalibrateArgon2(target time.Duration, memory uint32, threads uint8) (Argon2Params, error) {
+	return calibrate(target, memory, threads, argon2.IDKey)
+}
+
+// CalibrateKDF benchmarks the argon2i storage key derivation (KDFArgon2i) on the current machine like CalibrateArgon2.
+// The result is meant for Manager.SetKDFParams and DefaultKDFParams.
+func CalibrateKDF(target time.Duration, memory uint32, threads uint8) (Argon2Params, error) {
+	return calibrate(target, memory, threads, argon2Key)
+}
+
+// calibrate returns parameters of the argon2 variant derive that take about target per derivation (see CalibrateArgon2).
+func calibrate(target time.Duration, memory uint32, threads uint8, derive func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) (Argon2Params, error) {
+	if target <= 0 {
+		return Argon2Params{}, fmt.Errorf("%w: target must be positive", CalibrationErr)
+	}
+	params := Argon2Params{Time: 1, Memory: memory, Threads: threads}
+	err := params.validate()
+	if err != nil {
+		return Argon2Params{}, fmt.Errorf("%w: %w", CalibrationErr, err)
+	}
+
+	salt := make([]byte, phcSaltLength)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return Argon2Params{}, err
+	}
+	measure := func() time.Duration {
+		start := time.Now()
+		derive([]byte("calibration"), salt, params.Time, params.Memory, params.Threads, phcHashLength)
+		return max(time.Since(start), time.Nanosecond)
+	}
+
+	elapsed := measure()
+	for elapsed > target && params.Memory/2 >= 8*uint32(params.Threads) {
+		params.Memory /= 2
+		elapsed = measure()
+	}
+
+	if elapsed < target {
+		params.Time = uint32(min(max(target/elapsed, 1), time.Duration(maxKDFTime)))
+	}
+
+	return params, nil
+}
@@ -0,0 +1,149 @@
+package password
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManager_KDF(t *testing.T) {
+	// init test
+	fast := NewManagerWithStorage(NewTemporaryStorage())
+	fast.SetHashFunc(sha256Hash)
+	cheap := NewManagerWithStorage(NewTemporaryStorage())
+	params := Argon2Params{1, 64, 1}
+
+	err := cheap.SetKDFParams(Argon2Params{})
+	if !errors.Is(err, Argon2ParamsErr) || errors.Is(err, CorruptEntryErr) {
+		t.Errorf("SetKDFParams() error = %v, want %v", err, Argon2ParamsErr)
+	}
+	err = cheap.SetKDFParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := NewManager().GetKDF(); got != DefaultKDFParams.kdf() {
+		t.Errorf("GetKDF() = %v, want %v", got, DefaultKDFParams.kdf())
+	}
+
+	// managers record their own key derivation
+	tests := []struct {
+		name string
+		m    *Manager
+		want KDFParams
+	}{
+		{"custom hash", fast, KDFParams{Id: KDFCustom}},
+		{"argon2i params", cheap, params.kdf()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.GetKDF(); got != tt.want {
+				t.Errorf("GetKDF() = %v, want %v", got, tt.want)
+			}
+
+			err := tt.m.Overwrite("foo", "123", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := tt.m.storageBackend.Retrieve("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, err := ParseHeader(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.KDF != tt.want {
+				t.Errorf("ParseHeader() kdf = %v, want %v", header.KDF, tt.want)
+			}
+
+			got, err := tt.m.Get("foo", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "123" {
+				t.Errorf("Get() = %v, want 123", got)
+			}
+		})
+	}
+
+	// legacy entries use the hash function of the manager
+	oldHash := Hash
+	Hash = sha256Hash
+	legacy, err := legacyEncrypt("bar", "456")
+	Hash = oldHash
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fast.decryptWithKey(legacy, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "bar" {
+		t.Errorf("decryptWithKey() = %v, want bar", got)
+	}
+
+	// changing parameters keeps existing entries readable
+	err = cheap.SetKDFParams(Argon2Params{2, 128, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = cheap.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "123" {
+		t.Errorf("Get() = %v, want 123", got)
+	}
+	migrated, err := cheap.Migrate(func(string) string { return "456" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 1 {
+		t.Errorf("Migrate() = %v, want [foo]", migrated)
+	}
+}
+
+func TestCalibrateArgon2(t *testing.T) {
+	params, err := CalibrateArgon2(5*time.Millisecond, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Time < 1 || params.Memory < 8 || params.Memory > 1024 || params.Threads != 1 {
+		t.Errorf("CalibrateArgon2() = %v", params)
+	}
+
+	_, err = CalibrateArgon2(0, 1024, 1)
+	if !errors.Is(err, CalibrationErr) {
+		t.Errorf("CalibrateArgon2() error = %v, want %v", err, CalibrationErr)
+	}
+	_, err = CalibrateArgon2(time.Millisecond, 0, 1)
+	if !errors.Is(err, CalibrationErr) || !errors.Is(err, Argon2ParamsErr) {
+		t.Errorf("CalibrateArgon2() error = %v, want %v", err, Argon2ParamsErr)
+	}
+}
+
+func TestCalibrateKDF(t *testing.T) {
+	var derivations int
+	oldArgon2Key := argon2Key
+	argon2Key = func(password []byte, salt []byte, time uint32, memory uint32, threads uint8, keyLen uint32) []byte {
+		derivations++
+		return oldArgon2Key(password, salt, time, memory, threads, keyLen)
+	}
+	defer func() { argon2Key = oldArgon2Key }()
+
+	params, err := CalibrateKDF(5*time.Millisecond, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Time < 1 || params.Memory < 8 || params.Memory > 1024 || params.Threads != 1 {
+		t.Errorf("CalibrateKDF() = %v", params)
+	}
+	if derivations == 0 {
+		t.Errorf("CalibrateKDF() did not benchmark the storage key derivation")
+	}
+
+	_, err = CalibrateKDF(0, 1024, 1)
+	if !errors.Is(err, CalibrationErr) {
+		t.Errorf("CalibrateKDF() error = %v, want %v", err, CalibrationErr)
+	}
+}
@@ -0,0 +1,222 @@
+package password
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyCacheErr is wrapped by EnableKeyCache if the cache limits are invalid.
+var KeyCacheErr = errors.New("invalid key cache")
+
+// MaxKeyCacheSize is the maximum number of cached keys of EnableKeyCache.
+const MaxKeyCacheSize = 1 << 16
+
+// keyCacheKeyLength is the length of a derived key.
+const keyCacheKeyLength = 32
+
+// keyCache is a bounded cache of derived storage keys with a limited lifetime.
+// Entries are identified by a keyed hash of secret, salt and key derivation function, i.e. secrets are never stored.
+// Derived keys are held in a single SecureBuffer with one slot per entry and wiped on eviction.
+type keyCache struct {
+	// mutex controls thread-safe access to the cache.
+	mutex sync.Mutex
+
+	// size is the maximum number of entries. Zero disables the cache.
+	size int
+
+	// ttl is the lifetime of an entry after it was added.
+	ttl time.Duration
+
+	// salt is a random key of the entry tags.
+	salt []byte
+
+	// entries maps tags to elements of order.
+	entries map[[32]byte]*list.Element
+
+	// order holds *keyCacheEntry values from the most to the least recently used.
+	order *list.List
+
+	// keys holds size slots of derived keys.
+	keys *SecureBuffer
+
+	// free holds the indices of unused slots of keys.
+	free []int
+}
+
+// keyCacheEntry is a derived key in the cache.
+type keyCacheEntry struct {
+	tag     [32]byte
+	slot    int
+	expires time.Time
+}
+
+// enable resets the cache with new limits.
+func (c *keyCache) enable(size int, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+	c.keys.Destroy()
+	c.size, c.ttl = size, ttl
+	c.salt = make([]byte, saltLength)
+	_, _ = rand.Read(c.salt)
+	c.entries = make(map[[32]byte]*list.Element)
+	c.order = list.New()
+	c.keys = NewSecureBuffer(make([]byte, size*keyCacheKeyLength))
+	c.free = make([]int, size)
+	for i := range c.free {
+		c.free[i] = size - 1 - i
+	}
+}
+
+// disable wipes all entries and disables the cache.
+func (c *keyCache) disable() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+	c.keys.Destroy()
+	c.size, c.ttl = 0, 0
+	c.keys, c.free = nil, nil
+}
+
+// purge wipes all entries.
+func (c *keyCache) purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+}
+
+// reset wipes all entries. The mutex must be held.
+func (c *keyCache) reset() {
+	for _, element := range c.entries {
+		c.remove(element)
+	}
+}
+
+// remove wipes a single entry and frees its slot. The mutex must be held.
+func (c *keyCache) remove(element *list.Element) {
+	entry := element.Value.(*keyCacheEntry)
+	c.keys.writeAt(entry.slot*keyCacheKeyLength, make([]byte, keyCacheKeyLength))
+	c.free = append(c.free, entry.slot)
+	delete(c.entries, entry.tag)
+	c.order.Remove(element)
+}
+
+// len returns the number of entries.
+func (c *keyCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.entries)
+}
+
+// tag returns the keyed hash that identifies a derivation. The mutex must be held.
+func (c *keyCache) tag(p KDFParams, secret []byte, salt []byte) [32]byte {
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write(Header{HeaderVersion, 0, p}.marshal())
+	_ = binary.Write(mac, binary.BigEndian, uint64(len(salt)))
+	mac.Write(salt)
+	mac.Write(secret)
+	return [32]byte(mac.Sum(nil))
+}
+
+// deriveKey returns a cached key or derives it with p. The tag of the derivation is returned for add.
+// The boolean reports whether the key was cached. A nil cache always derives the key.
+// Keys of KDFCustom are never cached, since custom hash functions cannot be identified reliably,
+// e.g. closures of the same function literal share their code. They are returned with a zero tag, which add ignores.
+func (c *keyCache) deriveKey(p KDFParams, secret []byte, salt []byte, hash HashFunc) ([32]byte, [32]byte, bool) {
+	if c == nil || p.Id == KDFCustom {
+		return p.deriveKey(secret, salt, hash), [32]byte{}, false
+	}
+
+	c.mutex.Lock()
+	if c.size == 0 {
+		c.mutex.Unlock()
+		return p.deriveKey(secret, salt, hash), [32]byte{}, false
+	}
+
+	tag := c.tag(p, secret, salt)
+	element, found := c.entries[tag]
+	if found {
+		entry := element.Value.(*keyCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(element)
+			var key [32]byte
+			c.keys.readAt(entry.slot*keyCacheKeyLength, key[:])
+			c.mutex.Unlock()
+			return key, tag, true
+		}
+		c.remove(element)
+	}
+	c.mutex.Unlock()
+
+	// derive without holding the mutex
+	return p.deriveKey(secret, salt, hash), tag, false
+}
+
+// add stores a derived key that was verified by successful encryption or decryption.
+// The least recently used entry is evicted if the cache is full.
+func (c *keyCache) add(tag [32]byte, key [32]byte) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size == 0 || tag == ([32]byte{}) {
+		return
+	}
+	if element, found := c.entries[tag]; found {
+		c.remove(element)
+	}
+
+	// evict expired entries first, then the least recently used ones
+	now := time.Now()
+	for element := c.order.Back(); element != nil; {
+		previous := element.Prev()
+		if !now.Before(element.Value.(*keyCacheEntry).expires) {
+			c.remove(element)
+		}
+		element = previous
+	}
+	for len(c.entries) >= c.size {
+		c.remove(c.order.Back())
+	}
+
+	entry := &keyCacheEntry{tag: tag, slot: c.free[len(c.free)-1], expires: now.Add(c.ttl)}
+	c.free = c.free[:len(c.free)-1]
+	c.keys.writeAt(entry.slot*keyCacheKeyLength, key[:])
+	c.entries[tag] = c.order.PushFront(entry)
+}
+
+// EnableKeyCache caches up to size derived storage keys for ttl after they were added.
+// Repeated Get, Check and Set calls with the same storage key skip the expensive argon2 key derivation of existing ciphertexts.
+// Keys that are derived by custom hash functions (KDFCustom) are not cached.
+// Keys are only cached after successful encryption or decryption, are held in secure memory (see SecureBuffer)
+// and are wiped on eviction.
+// Calling EnableKeyCache again drops all cached keys.
+func (m *Manager) EnableKeyCache(size int, ttl time.Duration) error {
+	if size <= 0 || ttl <= 0 {
+		return fmt.Errorf("%w: size and ttl must be positive", KeyCacheErr)
+	}
+	if size > MaxKeyCacheSize {
+		return fmt.Errorf("%w: size exceeds %v", KeyCacheErr, MaxKeyCacheSize)
+	}
+	m.keyCache.enable(size, ttl)
+	return nil
+}
+
+// DisableKeyCache wipes all cached keys and disables the cache.
+func (m *Manager) DisableKeyCache() {
+	m.keyCache.disable()
+}
@@ -0,0 +1,169 @@
+package password
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManager_EnableKeyCache(t *testing.T) {
+	// init test
+	var derivations atomic.Int32
+	oldArgon2Key := argon2Key
+	argon2Key = func(password []byte, salt []byte, time uint32, memory uint32, threads uint8, keyLen uint32) []byte {
+		derivations.Add(1)
+		return oldArgon2Key(password, salt, time, memory, threads, keyLen)
+	}
+	defer func() { argon2Key = oldArgon2Key }()
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	err := m.SetKDFParams(Argon2Params{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, MaxKeyCacheSize + 1} {
+		err := m.EnableKeyCache(size, time.Minute)
+		if !errors.Is(err, KeyCacheErr) {
+			t.Errorf("EnableKeyCache(%v) error = %v, want %v", size, err, KeyCacheErr)
+		}
+	}
+	err = m.EnableKeyCache(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tests
+	tests := []struct {
+		name    string
+		key     string
+		want    int32
+		wantErr error
+	}{
+		{"cached on write", "456", 0, nil},
+		{"wrong key", "789", 1, AuthenticationErr},
+		{"wrong key not cached", "789", 1, AuthenticationErr},
+		{"cached again", "456", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derivations.Store(0)
+			got, err := m.Get("foo", tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != "123" {
+				t.Errorf("Get() = %v, want 123", got)
+			}
+			if got := derivations.Load(); got != tt.want {
+				t.Errorf("derivations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the cache is bounded
+	for _, id := range []string{"bar", "baz", "qux"} {
+		err = m.Overwrite(id, "123", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := m.keyCache.len(); got != 2 {
+		t.Errorf("len() = %v, want 2", got)
+	}
+	derivations.Store(0)
+	_, err = m.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := derivations.Load(); got != 1 {
+		t.Errorf("derivations of evicted key = %v, want 1", got)
+	}
+
+	// entries expire
+	err = m.EnableKeyCache(2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	derivations.Store(0)
+	_, err = m.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := derivations.Load(); got != 1 {
+		t.Errorf("derivations of expired key = %v, want 1", got)
+	}
+
+	// disabling wipes all entries
+	element := m.keyCache.order.Front()
+	if element == nil {
+		t.Fatal("cache is empty")
+	}
+	entry := element.Value.(*keyCacheEntry)
+	keys := m.keyCache.keys
+	var key [32]byte
+	keys.readAt(entry.slot*keyCacheKeyLength, key[:])
+	if key == [32]byte{} {
+		t.Errorf("cache holds an empty key")
+	}
+	m.keyCache.purge()
+	keys.readAt(entry.slot*keyCacheKeyLength, key[:])
+	if m.keyCache.len() != 0 || key != [32]byte{} {
+		t.Errorf("purge() did not wipe entries")
+	}
+	memory := keys.memory
+	m.DisableKeyCache()
+	if m.keyCache.len() != 0 || !memory.freed || keys.Len() != 0 {
+		t.Errorf("DisableKeyCache() did not wipe the keys")
+	}
+	derivations.Store(0)
+	_, err = m.Get("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.keyCache.len(); got != 0 || derivations.Load() != 1 {
+		t.Errorf("disabled cache: len() = %v, derivations = %v", got, derivations.Load())
+	}
+}
+
+func TestManager_EnableKeyCache_customHash(t *testing.T) {
+	// init test
+	var derivations atomic.Int32
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(func(data []byte, salt []byte) [32]byte {
+		derivations.Add(1)
+		return sha256Hash(data, salt)
+	})
+	err := m.EnableKeyCache(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys of custom hash functions are derived every time
+	for i := 0; i < 2; i++ {
+		derivations.Store(0)
+		_, err = m.Get("foo", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := derivations.Load(); got != 1 {
+			t.Errorf("derivations = %v, want 1", got)
+		}
+	}
+	if got := m.keyCache.len(); got != 0 {
+		t.Errorf("len() = %v, want 0", got)
+	}
+}
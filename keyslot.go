@@ -0,0 +1,389 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultKeySlot is the name of the key slot that holds the original storage key of an entry, after AddKeySlot was called.
+const DefaultKeySlot = "default"
+
+// slotsPrefix marks ciphertexts whose data key is wrapped by several named storage keys.
+// The colon is not part of the base64 alphabet, i.e. other ciphertexts never carry this prefix.
+const slotsPrefix = "slots1:"
+
+// maxKeySlotNameLength limits the length of key slot names.
+const maxKeySlotNameLength = 64
+
+// KeySlotErr is wrapped if a key slot name is invalid, already exists or cannot be removed.
+var KeySlotErr = errors.New("invalid key slot")
+
+// keySlot is a data key that is wrapped by a storage key.
+type keySlot struct {
+	name    string
+	wrapped []byte
+}
+
+// slottedData is a ciphertext with key slots.
+type slottedData struct {
+	cipher      CipherId
+	slots       []keySlot
+	cipherBytes []byte
+}
+
+// isSlotted reports whether a ciphertext has key slots.
+func isSlotted(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, slotsPrefix)
+}
+
+// validKeySlotName reports whether name only contains letters, digits, '.', '_' and '-'.
+func validKeySlotName(name string) bool {
+	if name == "" || len(name) > maxKeySlotNameLength {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSlots decodes a ciphertext with key slots:
+// "slots1:" + cipher id + ":" + name=base64(wrapped),... + ":" + base64(nonce + ciphertext)
+// Everything before the ciphertext is the header, which is authenticated as additional data.
+func parseSlots(ciphertext string) (slottedData, error) {
+	fields := strings.Split(strings.TrimPrefix(ciphertext, slotsPrefix), ":")
+	if len(fields) != 3 {
+		return slottedData{}, fmt.Errorf("%w: invalid key slot format", CorruptEntryErr)
+	}
+
+	n, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil || !CipherId(n).valid() {
+		return slottedData{}, fmt.Errorf("%w: unknown cipher %v", CorruptEntryErr, fields[0])
+	}
+	data := slottedData{cipher: CipherId(n)}
+
+	for _, field := range strings.Split(fields[1], ",") {
+		name, encoded, found := strings.Cut(field, "=")
+		if !found || !validKeySlotName(name) {
+			return slottedData{}, fmt.Errorf("%w: invalid key slot %v", CorruptEntryErr, name)
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return slottedData{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+		}
+		data.slots = append(data.slots, keySlot{name, wrapped})
+	}
+
+	data.cipherBytes, err = base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return slottedData{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+
+	return data, nil
+}
+
+// header encodes the cipher id and the key slots, i.e. everything but the ciphertext.
+func (d slottedData) header() string {
+	slots := make([]string, len(d.slots))
+	for i, slot := range d.slots {
+		slots[i] = slot.name + "=" + base64.StdEncoding.EncodeToString(slot.wrapped)
+	}
+	return slotsPrefix + strconv.Itoa(int(d.cipher)) + ":" + strings.Join(slots, ",")
+}
+
+// marshal encodes a ciphertext with key slots.
+func (d slottedData) marshal() string {
+	return d.header() + ":" + base64.StdEncoding.EncodeToString(d.cipherBytes)
+}
+
+// seal encrypts text with the data key. The header is authenticated as additional data,
+// i.e. seal must be called again after the slots changed.
+func (d *slottedData) seal(dek []byte, text []byte) error {
+	cipherBytes, err := sealAEAD(d.cipher, dek, text, []byte(d.header()))
+	if err != nil {
+		return err
+	}
+	d.cipherBytes = cipherBytes
+	return nil
+}
+
+// open decrypts the ciphertext with the data key and verifies the header.
+func (d slottedData) open(dek []byte) ([]byte, error) {
+	return openAEAD(d.cipher, dek, d.cipherBytes, []byte(d.header()))
+}
+
+// updateSlots applies update to the key slots and seals the ciphertext again for the new header.
+func (d *slottedData) updateSlots(dek []byte, update func() error) error {
+	text, err := d.open(dek)
+	if err != nil {
+		return err
+	}
+	defer clear(text)
+
+	err = update()
+	if err != nil {
+		return err
+	}
+	return d.seal(dek, text)
+}
+
+// index returns the index of the slot with name or -1.
+func (d slottedData) index(name string) int {
+	return slices.IndexFunc(d.slots, func(slot keySlot) bool { return slot.name == name })
+}
+
+// wrapSlotKey encrypts a data key with a key derived from the storage key.
+func (m *Manager) wrapSlotKey(dek []byte, key string) ([]byte, error) {
+	wrapped, err := m.encryptWithKey(base64.StdEncoding.EncodeToString(dek), key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(wrapped), nil
+}
+
+// unlockSlots returns the data key and the slot index of the first slot that is unlocked by key.
+// Every slot costs a key derivation, unless the derived key is cached (see EnableKeyCache).
+func (m *Manager) unlockSlots(data slottedData, key string) ([]byte, int, error) {
+	for i, slot := range data.slots {
+		encoded, err := m.decryptWithKey(string(slot.wrapped), key)
+		if err != nil {
+			continue
+		}
+		dek, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(dek) != envelopeKeyLength {
+			return nil, 0, fmt.Errorf("%w: invalid data key of slot %v", CorruptEntryErr, slot.name)
+		}
+		return dek, i, nil
+	}
+	return nil, 0, fmt.Errorf("%w: no matching key slot", AuthenticationErr)
+}
+
+// decryptSlots decrypts a ciphertext with key slots with the data key of the slot that is unlocked by key.
+func (m *Manager) decryptSlots(ciphertext string, key string) (string, error) {
+	data, err := parseSlots(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	dek, _, err := m.unlockSlots(data, key)
+	if err != nil {
+		return "", err
+	}
+	defer clear(dek)
+
+	textBytes, err := data.open(dek)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(textBytes) {
+		return "", fmt.Errorf("%w: invalid utf8 character after decryption", CorruptEntryErr)
+	}
+	return string(textBytes), nil
+}
+
+// resealSlots encrypts text with the data key of a stored ciphertext with key slots, if one of them is unlocked by key.
+// The slots are kept, i.e. all storage keys stay valid. The boolean reports whether the ciphertext was resealed.
+func (m *Manager) resealSlots(encryptedData string, text string, key string) (string, bool, error) {
+	data, err := parseSlots(encryptedData)
+	if err != nil {
+		return "", false, nil
+	}
+	dek, _, err := m.unlockSlots(data, key)
+	if err != nil {
+		return "", false, nil
+	}
+	defer clear(dek)
+
+	err = data.seal(dek, []byte(text))
+	if err != nil {
+		return "", false, err
+	}
+	return data.marshal(), true, nil
+}
+
+// rewrapSlot replaces the storage key of the slot that is unlocked by oldKey with newKey. The ciphertext is unchanged.
+func (m *Manager) rewrapSlot(ciphertext string, oldKey string, newKey string) (string, error) {
+	data, err := parseSlots(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	dek, i, err := m.unlockSlots(data, oldKey)
+	if err != nil {
+		return "", err
+	}
+	defer clear(dek)
+
+	err = data.updateSlots(dek, func() error {
+		data.slots[i].wrapped, err = m.wrapSlotKey(dek, newKey)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return data.marshal(), nil
+}
+
+// AddKeySlot allows reading and writing an existing entry with newKey in addition to existingKey.
+// The data key of the entry is wrapped by every key under a unique slot name. On first use, existingKey becomes the slot DefaultKeySlot.
+// Get, Check, Set and Unset accept the key of any slot. RewriteKey changes the key of the matching slot only.
+// Overwrite with a key that matches no slot replaces the entry including all slots.
+func (m *Manager) AddKeySlot(id string, existingKey string, newSlotName string, newKey string) error {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+	if !validKeySlotName(newSlotName) {
+		return fmt.Errorf("%w: invalid name %v", KeySlotErr, newSlotName)
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	encryptedData, err := m.storageBackend.Retrieve(id)
+	if err != nil {
+		return err
+	}
+
+	var data slottedData
+	var dek []byte
+	if isSlotted(encryptedData) {
+		data, err = parseSlots(encryptedData)
+		if err != nil {
+			return err
+		}
+		dek, _, err = m.unlockSlots(data, existingKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		packedData, err := m.decrypt(id, encryptedData, existingKey)
+		if err != nil {
+			return err
+		}
+
+		dek = make([]byte, envelopeKeyLength)
+		_, err = rand.Read(dek)
+		if err != nil {
+			return err
+		}
+		wrapped, err := m.wrapSlotKey(dek, existingKey)
+		if err != nil {
+			return err
+		}
+
+		data = slottedData{cipher: m.GetCipher(), slots: []keySlot{{DefaultKeySlot, wrapped}}}
+		err = data.seal(dek, []byte(packedData))
+		if err != nil {
+			return err
+		}
+	}
+	defer clear(dek)
+
+	if data.index(newSlotName) >= 0 {
+		return fmt.Errorf("%w: slot %v exists", KeySlotErr, newSlotName)
+	}
+	err = data.updateSlots(dek, func() error {
+		wrapped, err := m.wrapSlotKey(dek, newKey)
+		if err != nil {
+			return err
+		}
+		data.slots = append(data.slots, keySlot{newSlotName, wrapped})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.storageBackend.Store(id, data.marshal())
+}
+
+// RemoveKeySlot removes the slot with slotName from an entry. key must unlock either the removed slot or DefaultKeySlot,
+// i.e. holders of other slots cannot lock out the owner of the entry or each other. The last slot cannot be removed.
+func (m *Manager) RemoveKeySlot(id string, key string, slotName string) error {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	encryptedData, err := m.storageBackend.Retrieve(id)
+	if err != nil {
+		return err
+	}
+	if !isSlotted(encryptedData) {
+		return fmt.Errorf("%w: %v has no key slots", KeySlotErr, id)
+	}
+	data, err := parseSlots(encryptedData)
+	if err != nil {
+		return err
+	}
+
+	dek, j, err := m.unlockSlots(data, key)
+	if err != nil {
+		return err
+	}
+	defer clear(dek)
+
+	i := data.index(slotName)
+	if i < 0 {
+		return fmt.Errorf("%w: slot %v does not exist", KeySlotErr, slotName)
+	}
+	if j != i && data.slots[j].name != DefaultKeySlot {
+		return fmt.Errorf("%w: slot %v cannot remove slot %v", KeySlotErr, data.slots[j].name, slotName)
+	}
+	if len(data.slots) == 1 {
+		return fmt.Errorf("%w: cannot remove the last slot", KeySlotErr)
+	}
+	err = data.updateSlots(dek, func() error {
+		data.slots = slices.Delete(data.slots, i, i+1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.storageBackend.Store(id, data.marshal())
+}
+
+// ListKeySlots returns the slot names of an entry. Entries without key slots return DefaultKeySlot.
+func (m *Manager) ListKeySlots(id string) ([]string, error) {
+	id = NormalizeId(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedData, err := m.storageBackend.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if !isSlotted(encryptedData) {
+		return []string{DefaultKeySlot}, nil
+	}
+
+	data, err := parseSlots(encryptedData)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(data.slots))
+	for i, slot := range data.slots {
+		names[i] = slot.name
+	}
+	return names, nil
+}
@@ -0,0 +1,180 @@
+package password
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestManager_AddKeySlot(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableHistory(2)
+	err := m.Overwrite("foo", "123", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.AddKeySlot("foo", "wrong", "bob", "bob-key")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("AddKeySlot() error = %v, want %v", err, AuthenticationErr)
+	}
+	err = m.AddKeySlot("foo", "alice", "bob:", "bob-key")
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("AddKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+	err = m.AddKeySlot("foo", "alice", "bob", "bob-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddKeySlot("foo", "bob-key", "carol", "carol-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddKeySlot("foo", "carol-key", "bob", "other")
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("AddKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+
+	names, err := m.ListKeySlots("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{DefaultKeySlot, "bob", "carol"}) {
+		t.Errorf("ListKeySlots() = %v", names)
+	}
+
+	// every slot reads and writes the entry
+	tests := []struct {
+		name     string
+		key      string
+		password string
+	}{
+		{"default", "alice", "456"},
+		{"bob", "bob-key", "789"},
+		{"carol", "carol-key", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := m.Get("foo", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.Set("foo", old, tt.password, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, other := range tests {
+				ok, err := m.Check("foo", tt.password, other.key)
+				if err != nil || !ok {
+					t.Errorf("Check() with slot %v = %v, %v", other.name, ok, err)
+				}
+			}
+		})
+	}
+	_, err = m.Get("foo", "wrong")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+	}
+
+	// the slots header is authenticated
+	storedData, err := m.GetStorage().Retrieve("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parseSlots(storedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.slots = slices.Delete(data.slots, 1, 2)
+	err = m.GetStorage().Store("foo", data.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get("foo", "alice")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+	}
+	err = m.GetStorage().Store("foo", storedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewriting a key only changes its slot
+	err = m.RewriteKey("foo", "bob-key", "bob-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"alice", "bob-new", "carol-key"} {
+		got, err := m.Get("foo", key)
+		if err != nil || got != "abc" {
+			t.Errorf("Get() with %v = %v, %v", key, got, err)
+		}
+	}
+	_, err = m.Get("foo", "bob-key")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+	}
+
+	// removing slots requires the key of the slot or of the default slot
+	err = m.RemoveKeySlot("foo", "carol-key", "bob")
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("RemoveKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+	err = m.RemoveKeySlot("foo", "carol-key", DefaultKeySlot)
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("RemoveKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+	err = m.RemoveKeySlot("foo", "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get("foo", "bob-new")
+	if !errors.Is(err, AuthenticationErr) {
+		t.Errorf("Get() error = %v, want %v", err, AuthenticationErr)
+	}
+	err = m.RemoveKeySlot("foo", "alice", "bob")
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("RemoveKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+	err = m.RemoveKeySlot("foo", "carol-key", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddKeySlot("foo", "alice", "carol", "carol-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RemoveKeySlot("foo", "alice", DefaultKeySlot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RemoveKeySlot("foo", "carol-key", "carol")
+	if !errors.Is(err, KeySlotErr) {
+		t.Errorf("RemoveKeySlot() error = %v, want %v", err, KeySlotErr)
+	}
+
+	// all keys of the store rotate
+	err = m.RewriteAllKeys("carol-key", "dave", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Get("foo", "dave")
+	if err != nil || got != "abc" {
+		t.Errorf("Get() after RewriteAllKeys() = %v, %v", got, err)
+	}
+
+	// overwriting with an unknown key replaces all slots
+	err = m.Overwrite("foo", "xyz", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err = m.ListKeySlots("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{DefaultKeySlot}) {
+		t.Errorf("ListKeySlots() = %v", names)
+	}
+}
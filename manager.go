@@ -1,90 +1,421 @@
 package password
 
 import (
+	"crypto/ecdh"
+	"errors"
 	"fmt"
 	"github.com/image357/password/log"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 // RecoveryIdSuffix stores the id/file suffix that identifies recovery key files.
 const RecoveryIdSuffix string = ".recovery"
 
+// IncorrectPasswordErr is returned by Set and Unset if the provided password does not match the stored one.
+var IncorrectPasswordErr = errors.New("password is incorrect")
+
+// ExpiredErr is wrapped by Get, Check and GetEntry if the expiry time of a password has passed.
+var ExpiredErr = errors.New("password expired")
+
+// IdMismatchErr is wrapped if the id inside a decrypted entry does not match the requested id.
+var IdMismatchErr = errors.New("storage id mismatch")
+
+// Entry holds a stored password together with the metadata inside its encrypted envelope.
+type Entry struct {
+	// Id is the normalized password-id.
+	Id string
+
+	// Password is the stored password or its hash if the entry was written with Manager.HashPassword.
+	Password string
+
+	// Created is the time of the first write of this id.
+	Created time.Time
+
+	// Modified is the time of the last password write of this id.
+	Modified time.Time
+
+	// Labels are user-defined key/value pairs that are encrypted alongside the password.
+	Labels map[string]string
+
+	// Expires is the time after which the password is no longer returned. The zero value never expires.
+	Expires time.Time
+}
+
+// Expired reports whether the expiry time of the entry has passed.
+func (e Entry) Expired() bool {
+	return !e.Expires.IsZero() && !time.Now().Before(e.Expires)
+}
+
 type Manager struct {
 	// HashPassword signals if passwords will be stored as hashes.
 	HashPassword bool
 
+	// hashParams are the argon2id cost parameters of new password hashes. The zero value selects DefaultArgon2Params.
+	hashParams Argon2Params
+
+	// hashFunc replaces the global Hash function if not nil.
+	hashFunc HashFunc
+
+	// kdf is the key derivation function of storage keys for new entries. The zero value selects the global default.
+	kdf KDFParams
+
+	// hashUpgrade signals that imported foreign hashes are replaced with argon2id hashes after a successful Check.
+	hashUpgrade bool
+
 	// withRecovery signals that a recovery key file must be stored alongside passwords.
 	withRecovery bool
 
-	// recoveryKeyBytes store the result of EncryptOTP such that the recovery key is obfuscated in memory.
-	recoveryKeyBytes []byte
-	// recoveryKeySecret store the result of EncryptOTP such that the recovery key is obfuscated in memory.
-	recoveryKeySecret []byte
+	// recoveryKey holds the recovery key in secure memory.
+	recoveryKey *SecureBuffer
+
+	// recoveryPublicKey encrypts recovery entries if EnablePublicKeyRecovery was called.
+	recoveryPublicKey *ecdh.PublicKey
+
+	// recoveryMutex controls thread-safe access to recoveryKey and recoveryPublicKey.
+	recoveryMutex sync.RWMutex
+
+	// passwordPolicy validates new passwords in Set and Overwrite.
+	passwordPolicy PasswordPolicy
+
+	// historySize stores the number of previous versions that are kept for every password.
+	historySize int
+
+	// cipher is the authenticated cipher of new entries. The zero value selects CipherAES256GCM.
+	cipher CipherId
+
+	// envelope signals that new entries are encrypted with per-entry data keys.
+	envelope bool
+
+	// keyringMutex controls thread-safe access to the cached key-encryption key.
+	keyringMutex sync.Mutex
+	// keyringWriteMutex is held in read mode while entries are sealed and stored, and in write mode by Clean.
+	keyringWriteMutex sync.RWMutex
+	// keyringSalt is a random salt for keyringKeyTag.
+	keyringSalt []byte
+	// keyringKeyTag identifies the storage key that unlocked the cached key-encryption key.
+	keyringKeyTag []byte
+	// keyringKeyBuffer holds the cached key-encryption key in secure memory.
+	keyringKeyBuffer *SecureBuffer
+
+	// keyCache holds derived storage keys if EnableKeyCache was called.
+	keyCache keyCache
 
 	// storageBackend handles password storage.
 	storageBackend Storage
+
+	// idTree holds an id to sync.Mutex map that serializes check-then-write operations on the same id.
+	idTree map[string]*sync.Mutex
+
+	// idTreeLockCount holds an id to count map for cleaning up unused sync.Mutex entries in idTree.
+	idTreeLockCount map[string]int
+
+	// idTreeMutex controls thread-safe access to the idTree.
+	idTreeMutex sync.Mutex
+
+	// reaperStop signals the background reaper to stop.
+	reaperStop chan struct{}
+
+	// reaperDone is closed when the background reaper has stopped.
+	reaperDone chan struct{}
+
+	// reaperMutex controls thread-safe access to the background reaper.
+	reaperMutex sync.Mutex
 }
 
 // NewManager creates a new passwordManager instance and applies basic initialization.
+// Passwords are stored in a FileStorage backend.
 func NewManager() *Manager {
+	return NewManagerWithStorage(NewFileStorage())
+}
+
+// NewManagerWithStorage creates a new passwordManager instance that stores passwords in the provided storage backend.
+func NewManagerWithStorage(storage Storage) *Manager {
 	m := new(Manager)
 
 	m.HashPassword = false
 	m.withRecovery = false
-	m.storageBackend = NewFileStorage()
+	m.storageBackend = storage
 
 	return m
 }
 
+// GetStorage returns the current storage backend.
+func (m *Manager) GetStorage() Storage {
+	return m.storageBackend
+}
+
+// SetStorage overwrites the current storage backend with any implementation of the Storage interface.
+func (m *Manager) SetStorage(storage Storage) {
+	m.storageBackend = storage
+}
+
+// GetStorePath returns the current storage path with system-specific path separators.
+// The storage backend must provide a storage path (e.g. FileStorage).
+func (m *Manager) GetStorePath() (string, error) {
+	s, ok := m.storageBackend.(pathStorage)
+	if !ok {
+		return "", UnsupportedStorageErr
+	}
+	return s.GetStorePath(), nil
+}
+
+// SetStorePath accepts a new storage path with system-unspecific or mixed path separators.
+// The storage backend must provide a storage path (e.g. FileStorage).
+func (m *Manager) SetStorePath(path string) error {
+	s, ok := m.storageBackend.(pathStorage)
+	if !ok {
+		return UnsupportedStorageErr
+	}
+	s.SetStorePath(path)
+	return nil
+}
+
+// FilePath returns the storage filepath of a given password-id with system-specific path separators.
+// It accepts system-unspecific or mixed id separators, i.e. forward- and backward-slashes are treated as the same character.
+// The storage backend must provide a storage path (e.g. FileStorage).
+func (m *Manager) FilePath(id string) (string, error) {
+	s, ok := m.storageBackend.(pathStorage)
+	if !ok {
+		return "", UnsupportedStorageErr
+	}
+	return s.FilePath(id), nil
+}
+
+// DumpJSON serializes the storage backend to a JSON string.
+func (m *Manager) DumpJSON() (string, error) {
+	if m.storageBackend == nil {
+		return "", UnsupportedStorageErr
+	}
+	return m.storageBackend.DumpJSON()
+}
+
+// LoadJSON deserializes a JSON string into the storage backend.
+func (m *Manager) LoadJSON(input string) error {
+	if m.storageBackend == nil {
+		return UnsupportedStorageErr
+	}
+	return m.storageBackend.LoadJSON(input)
+}
+
+// WriteToDisk saves the current storage to files via FileStorage mechanisms.
+// The storage backend must support disk synchronization (e.g. TemporaryStorage).
+// Warning: This method does not block operations on the underlying storage backends (read/write/create/delete).
+// You should stop operations manually before usage or ignore the reported error.
+// Data consistency is guaranteed.
+func (m *Manager) WriteToDisk(path string) error {
+	s, ok := m.storageBackend.(diskStorage)
+	if !ok {
+		return UnsupportedStorageErr
+	}
+	return s.WriteToDisk(path)
+}
+
+// ReadFromDisk loads a FileStorage backend from disk into the current storage.
+// The storage backend must support disk synchronization (e.g. TemporaryStorage).
+// Warning: This method does not block operations on the underlying storage backends (read/write/create/delete).
+// You should stop operations manually before usage or ignore the reported error.
+// Data consistency is guaranteed.
+func (m *Manager) ReadFromDisk(path string) error {
+	s, ok := m.storageBackend.(diskStorage)
+	if !ok {
+		return UnsupportedStorageErr
+	}
+	return s.ReadFromDisk(path)
+}
+
 // EnableRecovery will enforce recovery key file storage alongside passwords.
+// The recovery key is held in secure memory (see SecureBuffer) until DisableRecovery is called.
 func (m *Manager) EnableRecovery(key string) {
+	keyBytes := []byte(key)
+	defer clear(keyBytes)
+
+	m.enableRecoveryKey(keyBytes)
+}
+
+// enableRecoveryKey copies key into secure memory and enforces recovery key file storage.
+// The caller should wipe key afterward.
+func (m *Manager) enableRecoveryKey(key []byte) {
+	previous := m.swapRecoveryKey(NewSecureBuffer(key), nil)
 	m.withRecovery = true
-	m.recoveryKeyBytes, m.recoveryKeySecret = EncryptOTP(key)
+	previous.Destroy()
 }
 
 // DisableRecovery will stop recovery key file storage alongside passwords.
+// The recovery key is wiped from memory.
 func (m *Manager) DisableRecovery() {
 	m.withRecovery = false
-	m.recoveryKeyBytes, m.recoveryKeySecret = nil, nil
+	previous := m.swapRecoveryKey(nil, nil)
+	previous.Destroy()
 }
 
-// getRecoveryKey returns the recovery key that was set by EnableRecovery.
-func (m *Manager) getRecoveryKey() string {
-	return DecryptOTP(m.recoveryKeyBytes, m.recoveryKeySecret)
+// swapRecoveryKey replaces the recovery key and the recovery public key and returns the previous recovery key.
+// The previous recovery key is no longer used by other goroutines and can be destroyed by the caller.
+func (m *Manager) swapRecoveryKey(key *SecureBuffer, publicKey *ecdh.PublicKey) *SecureBuffer {
+	m.recoveryMutex.Lock()
+	defer m.recoveryMutex.Unlock()
+
+	previous := m.recoveryKey
+	m.recoveryKey, m.recoveryPublicKey = key, publicKey
+	return previous
+}
+
+// hasRecoveryPublicKey reports whether recovery entries are encrypted to a public key (see EnablePublicKeyRecovery).
+func (m *Manager) hasRecoveryPublicKey() bool {
+	m.recoveryMutex.RLock()
+	defer m.recoveryMutex.RUnlock()
+	return m.recoveryPublicKey != nil
+}
+
+// useRecoveryKey calls fn with the recovery key that was set by EnableRecovery without copying it to the Go heap.
+// The key must not be retained after fn returns (see SecureBuffer.Use).
+// The recovery key cannot be replaced while fn runs. If no recovery key is set, fn is not called and DestroyedBufferErr is returned.
+func (m *Manager) useRecoveryKey(fn func(recoveryKey string)) error {
+	m.recoveryMutex.RLock()
+	defer m.recoveryMutex.RUnlock()
+	return m.recoveryKey.Use(fn)
+}
+
+// lockId locks an id mutex by first locking the id tree and increasing lock count.
+// The id tree is created on first usage.
+func (m *Manager) lockId(id string) {
+	// get mutex with side effects (create if necessary)
+	m.idTreeMutex.Lock()
+	if m.idTree == nil {
+		m.idTree = make(map[string]*sync.Mutex)
+		m.idTreeLockCount = make(map[string]int)
+	}
+	idMutex, ok := m.idTree[id]
+	if !ok {
+		idMutex = &sync.Mutex{}
+		m.idTree[id] = idMutex
+	}
+	m.idTreeLockCount[id]++
+	m.idTreeMutex.Unlock()
+
+	// lock mutex
+	idMutex.Lock()
+}
+
+// unlockId unlocks an id mutex and decreases lock count.
+// The id tree is cleaned from id if lock count is zero.
+func (m *Manager) unlockId(id string) {
+	m.idTreeMutex.Lock()
+	defer m.idTreeMutex.Unlock()
+
+	idMutex, ok := m.idTree[id]
+	if !ok {
+		// abort on missing mutex
+		return
+	}
+	idMutex.Unlock()
+
+	// cleanup if last lock
+	m.idTreeLockCount[id]--
+	if m.idTreeLockCount[id] <= 0 {
+		delete(m.idTree, id)
+		delete(m.idTreeLockCount, id)
+	}
 }
 
 // Overwrite an existing password or create a new one.
 // key is the encryption secret for storage.
+// A PolicyError is returned if the password violates the password policy.
 func (m *Manager) Overwrite(id string, password string, key string) error {
+	return m.OverwriteWithExpiry(id, password, key, time.Time{})
+}
+
+// OverwriteWithExpiry overwrites an existing password or creates a new one that expires at the given time.
+// key is the encryption secret for storage.
+// The expiry time is stored with second precision. A zero expiry time never expires.
+func (m *Manager) OverwriteWithExpiry(id string, password string, key string, expires time.Time) error {
 	id = NormalizeId(id)
 
-	if m.HashPassword && !(m.withRecovery && strings.HasSuffix(id, RecoveryIdSuffix)) {
-		hashedPassword, err := getHashedPassword(password)
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	err = m.checkPolicy(id, password, key)
+	if err != nil {
+		return err
+	}
+
+	return m.overwrite(id, password, key, expires)
+}
+
+// overwrite stores a password without locking the normalized id.
+// Metadata of an existing entry is preserved if it can be decrypted with key. If key does not match
+// or the entry is corrupt, it is replaced with a fresh entry. Storage errors are returned.
+// Recovery entries have no metadata and are never read.
+func (m *Manager) overwrite(id string, password string, key string, expires time.Time) error {
+	entry := Entry{Id: id}
+	if !strings.HasSuffix(id, RecoveryIdSuffix) {
+		storedEntry, err := m.getEntry(id, key)
+		if err == nil {
+			entry = storedEntry
+		} else if !errors.Is(err, NotFoundErr) && !errors.Is(err, AuthenticationErr) &&
+			!errors.Is(err, CorruptEntryErr) && !errors.Is(err, IdMismatchErr) {
+			return err
+		}
+	}
+	entry.Expires = expires
+	return m.write(entry, password, key)
+}
+
+// write updates the password of an entry and stores it.
+// If enabled, the password is hashed and a recovery entry is written.
+func (m *Manager) write(entry Entry, password string, key string) error {
+	id := entry.Id
+	if m.isHashed(id) {
+		hashedPassword, err := getHashedPassword(password, m.GetHashParams())
 		if err != nil {
 			return err
 		}
 		password = hashedPassword
 	}
 
-	packedData, err := packData(id, password)
-	if err != nil {
+	return m.commit(entry, password, key)
+}
+
+// commit stores the final (possibly hashed) password of an entry.
+// Timestamps are updated, the previous version is kept if history is enabled and a recovery entry is written.
+func (m *Manager) commit(entry Entry, password string, key string) error {
+	id := entry.Id
+
+	// the replaced ciphertext keeps its key slots and is recorded as version after the new one was stored
+	previousData, err := m.storageBackend.Retrieve(id)
+	if err != nil && !errors.Is(err, NotFoundErr) {
 		return err
 	}
+	withHistory := err == nil && m.historySize > 0 && !strings.HasSuffix(id, RecoveryIdSuffix)
 
-	encryptedData, err := Encrypt(packedData, key)
-	if err != nil {
-		return err
+	now := time.Now()
+	entry.Password = password
+	entry.Modified = now
+	if entry.Created.IsZero() {
+		entry.Created = now
 	}
 
-	err = m.storageBackend.Store(id, encryptedData)
+	err = m.storeEntry(entry, key, previousData)
 	if err != nil {
 		return err
 	}
 
+	if withHistory {
+		m.pushHistory(id, previousData)
+	}
+
 	if m.withRecovery && !strings.HasSuffix(id, RecoveryIdSuffix) {
 		// write recovery key file
 		recoveryId := id + RecoveryIdSuffix
-		err = m.Overwrite(recoveryId, key, m.getRecoveryKey())
+		err = m.writeRecovery(recoveryId, key)
 		if err != nil {
 			log.Warn("cannot write recovery key file", "id", recoveryId)
 		}
@@ -93,77 +424,199 @@ func (m *Manager) Overwrite(id string, password string, key string) error {
 	return nil
 }
 
+// storeEntry encrypts an entry with key and stores it in the storage backend.
+// storedData is the stored ciphertext of the entry or empty. Its key slots are kept if key unlocks one of them.
+func (m *Manager) storeEntry(entry Entry, key string, storedData string) error {
+	packedData, err := packEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	return m.storeSealed(entry.Id, func() (string, error) {
+		if isSlotted(storedData) {
+			encryptedData, resealed, err := m.resealSlots(storedData, packedData, key)
+			if err != nil || resealed {
+				return encryptedData, err
+			}
+		}
+		return m.encrypt(entry.Id, packedData, key)
+	})
+}
+
 // Get an existing password with id.
 // key is the encryption secret for storage.
 func (m *Manager) Get(id string, key string) (string, error) {
+	entry, err := m.GetEntry(id, key)
+	if err != nil {
+		return "", err
+	}
+	return entry.Password, nil
+}
+
+// GetEntry returns an existing password with id together with its metadata.
+// key is the encryption secret for storage.
+// Entries that were written without metadata report their last modification as creation time.
+func (m *Manager) GetEntry(id string, key string) (Entry, error) {
 	id = NormalizeId(id)
 
+	err := checkReservedId(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry, err := m.getEntry(id, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	if entry.Expired() {
+		return Entry{}, fmt.Errorf("%w: %v at %v", ExpiredErr, id, entry.Expires.Format(timeFormat))
+	}
+
+	return entry, nil
+}
+
+// getEntry returns the entry of a normalized id regardless of its expiry time.
+func (m *Manager) getEntry(id string, key string) (Entry, error) {
 	encryptedData, err := m.storageBackend.Retrieve(id)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
 
-	packedData, err := Decrypt(encryptedData, key)
+	return m.decryptEntry(id, encryptedData, key)
+}
+
+// decryptEntry decrypts stored data of a normalized id and verifies the contained id.
+func (m *Manager) decryptEntry(id string, encryptedData string, key string) (Entry, error) {
+	packedData, err := m.decrypt(id, encryptedData, key)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
 
-	storedId, password, err := unpackData(packedData)
+	entry, err := unpackEntry(packedData)
 	if err != nil {
-		return "", err
+		return Entry{}, err
 	}
-	if storedId != id {
-		return "", fmt.Errorf("storage id mismatch")
+	if entry.Id != id {
+		return Entry{}, fmt.Errorf("%w: got %v, want %v", IdMismatchErr, entry.Id, id)
 	}
 
-	return password, nil
+	return entry, nil
+}
+
+// SetLabels replaces the user-defined labels of an existing password.
+// key is the encryption secret for storage.
+// The password and its timestamps are unchanged.
+func (m *Manager) SetLabels(id string, labels map[string]string, key string) error {
+	id = NormalizeId(id)
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	entry, err := m.GetEntry(id, key)
+	if err != nil {
+		return err
+	}
+	storedData, err := m.storageBackend.Retrieve(id)
+	if err != nil {
+		return err
+	}
+
+	entry.Labels = maps.Clone(labels)
+	return m.storeEntry(entry, key, storedData)
 }
 
 // Check an existing password for equality with the provided password.
 // key is the encryption secret for storage.
+// If hashing is enabled, matching passwords with legacy hashes or weaker parameters than GetHashParams are rehashed.
 func (m *Manager) Check(id string, password string, key string) (bool, error) {
 	id = NormalizeId(id)
 
-	decryptedPassword, err := m.Get(id, key)
+	entry, err := m.GetEntry(id, key)
 	if err != nil {
 		return false, err
 	}
 
-	var result bool
-	if m.HashPassword && !(m.withRecovery && strings.HasSuffix(id, RecoveryIdSuffix)) {
-		result, err = compareHashedPassword(decryptedPassword, password)
-		if err != nil {
-			return false, err
-		}
-	} else {
-		result = comparePassword(decryptedPassword, password)
+	equal, err := m.compare(entry, password)
+	if err != nil || !equal {
+		return equal, err
+	}
+
+	if m.needsRehash(entry) {
+		m.rehash(entry, password, key)
 	}
 
-	return result, nil
+	return true, nil
+}
+
+// isHashed reports whether the password of a normalized id is stored as hash.
+func (m *Manager) isHashed(id string) bool {
+	return m.HashPassword && !(m.withRecovery && strings.HasSuffix(id, RecoveryIdSuffix))
+}
+
+// compare tests the stored password of an entry for equality with the provided password.
+func (m *Manager) compare(entry Entry, password string) (bool, error) {
+	if m.isHashed(entry.Id) {
+		return compareHashedPasswordWithHash(entry.Password, password, m.getHash())
+	}
+	return comparePassword(entry.Password, password), nil
 }
 
 // Set an existing password-id or create a new one.
 // oldPassword must match the currently stored password.
 // key is the encryption secret for storage.
+// The id is locked between check and write, i.e. concurrent calls on the same manager are linearizable.
+// A PolicyError is returned if newPassword violates the password policy.
 func (m *Manager) Set(id string, oldPassword string, newPassword string, key string) error {
+	return m.SetWithExpiry(id, oldPassword, newPassword, key, time.Time{})
+}
+
+// SetWithExpiry sets an existing password-id or creates a new one that expires at the given time.
+// oldPassword must match the currently stored password. Expired passwords are treated as missing.
+// key is the encryption secret for storage.
+// The expiry time is stored with second precision. A zero expiry time never expires.
+func (m *Manager) SetWithExpiry(id string, oldPassword string, newPassword string, key string, expires time.Time) error {
 	id = NormalizeId(id)
 
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
 	exists, err := m.storageBackend.Exists(id)
 	if err != nil {
 		return err
 	}
 
+	entry := Entry{Id: id}
 	if exists {
-		correct, err := m.Check(id, oldPassword, key)
+		entry, err = m.getEntry(id, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if entry.Expired() {
+		entry = Entry{Id: id}
+	} else if exists {
+		correct, err := m.compare(entry, oldPassword)
 		if err != nil {
 			return err
 		}
 		if !correct {
-			return fmt.Errorf("password is incorrect")
+			return IncorrectPasswordErr
 		}
 	}
 
-	err = m.Overwrite(id, newPassword, key)
+	err = m.checkPolicy(id, newPassword, key)
+	if err != nil {
+		return err
+	}
+
+	entry.Expires = expires
+	err = m.write(entry, newPassword, key)
 	if err != nil {
 		return err
 	}
@@ -173,18 +626,33 @@ func (m *Manager) Set(id string, oldPassword string, newPassword string, key str
 // Unset (delete) an existing password.
 // password must match the currently stored password.
 // key is the encryption secret for storage.
+// The id is locked between check and delete, i.e. concurrent calls on the same manager are linearizable.
+// Stored versions and the recovery entry of the password are deleted as well.
 func (m *Manager) Unset(id string, password string, key string) error {
 	id = NormalizeId(id)
 
-	correct, err := m.Check(id, password, key)
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	// Check would rehash and lock the id again
+	entry, err := m.GetEntry(id, key)
+	if err != nil {
+		return err
+	}
+	correct, err := m.compare(entry, password)
 	if err != nil {
 		return err
 	}
 	if !correct {
-		return fmt.Errorf("password is incorrect")
+		return IncorrectPasswordErr
 	}
 
-	return m.storageBackend.Delete(id)
+	return m.delete(id)
 }
 
 // Exists tests if a given id already exists in the storage backend.
@@ -193,44 +661,135 @@ func (m *Manager) Exists(id string) (bool, error) {
 	return m.storageBackend.Exists(id)
 }
 
-// List all stored password-ids.
+// List all stored password-ids. Stored versions (see EnableHistory) and the keyring (see KeyringId) are not listed.
 func (m *Manager) List() ([]string, error) {
-	return m.storageBackend.List()
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ids, func(id string) bool {
+		return isHistoryId(id) || id == KeyringId
+	}), nil
+}
+
+// ListPasswords returns the sorted ids of all stored passwords without recovery entries, versions and the keyring.
+// Additionally, it returns the ids of orphaned recovery entries, i.e. recovery entries whose password does not exist.
+// Use Delete to remove orphaned recovery entries.
+func (m *Manager) ListPasswords() ([]string, []string, error) {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exists := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		exists[id] = true
+	}
+
+	passwords := make([]string, 0, len(ids))
+	orphaned := make([]string, 0)
+	for _, id := range ids {
+		if isPasswordId(id) {
+			passwords = append(passwords, id)
+		} else if owner, found := strings.CutSuffix(id, RecoveryIdSuffix); found && !exists[owner] {
+			orphaned = append(orphaned, id)
+		}
+	}
+	slices.Sort(passwords)
+	slices.Sort(orphaned)
+
+	return passwords, orphaned, nil
 }
 
 // Delete an existing password.
+// Stored versions and the recovery entry of the password are deleted as well.
 func (m *Manager) Delete(id string) error {
 	id = NormalizeId(id)
-	return m.storageBackend.Delete(id)
+
+	err := checkReservedId(id)
+	if err != nil {
+		return err
+	}
+
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	return m.delete(id)
+}
+
+// delete removes a password, its versions and its recovery entry without locking the normalized id.
+// The recovery entry holds the storage key of the password, i.e. it must not outlive the password.
+func (m *Manager) delete(id string) error {
+	err := m.storageBackend.Delete(id)
+	if err != nil && !errors.Is(err, NotFoundErr) {
+		return err
+	}
+
+	historyErr := m.deleteHistory(id)
+	if historyErr != nil {
+		return historyErr
+	}
+
+	if !strings.HasSuffix(id, RecoveryIdSuffix) {
+		recoveryErr := m.storageBackend.Delete(id + RecoveryIdSuffix)
+		if recoveryErr != nil && !errors.Is(recoveryErr, NotFoundErr) {
+			return recoveryErr
+		}
+	}
+	return err
 }
 
-// Clean (delete) all stored passwords.
+// Clean (delete) all stored passwords including their versions and recovery entries.
+// Envelope entries that are written concurrently are stored before the keyring is deleted.
 func (m *Manager) Clean() error {
+	m.keyringWriteMutex.Lock()
+	defer m.keyringWriteMutex.Unlock()
+	m.keyringMutex.Lock()
+	defer m.keyringMutex.Unlock()
+	m.resetKeyringCache()
+	m.keyCache.purge()
+
 	return m.storageBackend.Clean()
 }
 
 // RewriteKey changes the storage key of a password from oldKey to newKey.
 // Encryption hashes will be renewed. Stored metadata will be unchanged.
 // If enabled, recovery entries will be recreated.
+// Stored versions that were encrypted with oldKey are rewritten as well.
+// Envelope encrypted stores share a single master key, use RotateMasterKey instead.
 func (m *Manager) RewriteKey(id string, oldKey string, newKey string) error {
 	id = NormalizeId(id)
 
-	encryptedData, err := m.storageBackend.Retrieve(id)
+	err := checkReservedId(id)
 	if err != nil {
 		return err
 	}
 
-	packedData, err := Decrypt(encryptedData, oldKey)
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	encryptedData, err := m.storageBackend.Retrieve(id)
 	if err != nil {
 		return err
 	}
 
-	newData, err := Encrypt(packedData, newKey)
+	err = m.storeSealed(id, func() (string, error) {
+		if isSlotted(encryptedData) {
+			// only the slot of oldKey changes
+			return m.rewrapSlot(encryptedData, oldKey, newKey)
+		}
+
+		packedData, err := m.decrypt(id, encryptedData, oldKey)
+		if err != nil {
+			return "", err
+		}
+		return m.encrypt(id, packedData, newKey)
+	})
 	if err != nil {
 		return err
 	}
 
-	err = m.storageBackend.Store(id, newData)
+	err = m.rewriteHistoryKey(id, oldKey, newKey)
 	if err != nil {
 		return err
 	}
@@ -238,7 +797,7 @@ func (m *Manager) RewriteKey(id string, oldKey string, newKey string) error {
 	if m.withRecovery && !strings.HasSuffix(id, RecoveryIdSuffix) {
 		// write recovery key file
 		recoveryId := id + RecoveryIdSuffix
-		err = m.Overwrite(recoveryId, newKey, m.getRecoveryKey())
+		err = m.writeRecovery(recoveryId, newKey)
 		if err != nil {
 			log.Warn("cannot write recovery key file", "id", recoveryId)
 		}
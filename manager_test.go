@@ -1,9 +1,16 @@
 package password
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewManager(t *testing.T) {
@@ -26,6 +33,172 @@ func TestNewManager(t *testing.T) {
 	}
 }
 
+func TestNewManagerWithStorage(t *testing.T) {
+	type args struct {
+		storage Storage
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Manager
+	}{
+		{"file", args{NewFileStorage()}, &Manager{storageBackend: NewFileStorage()}},
+		{"temporary", args{NewTemporaryStorage()}, &Manager{storageBackend: NewTemporaryStorage()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewManagerWithStorage(tt.args.storage); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewManagerWithStorage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_SetStorage_GetStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage Storage
+	}{
+		{"file", NewFileStorage()},
+		{"temporary", NewTemporaryStorage()},
+		{"nil", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			m.SetStorage(tt.storage)
+			if got := m.GetStorage(); got != tt.storage {
+				t.Errorf("GetStorage() = %v, want %v", got, tt.storage)
+			}
+		})
+	}
+}
+
+func TestManager_StorePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage Storage
+		path    string
+		wantErr bool
+	}{
+		{"file", NewFileStorage(), "tests/workdir/Manager_StorePath", false},
+		{"temporary", NewTemporaryStorage(), "tests/workdir/Manager_StorePath", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManagerWithStorage(tt.storage)
+
+			err := m.SetStorePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetStorePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := m.GetStorePath()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetStorePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := ""
+			if !tt.wantErr {
+				want, _ = filepath.Abs(tt.path)
+			}
+			if got != want {
+				t.Errorf("GetStorePath() = %v, want %v", got, want)
+			}
+
+			got, err = m.FilePath("foo/bar")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FilePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				want = filepath.Join(want, "foo", "bar."+DefaultFileEnding)
+			}
+			if got != want {
+				t.Errorf("FilePath() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestManager_DumpJSON_LoadJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage Storage
+		input   string
+		wantErr bool
+	}{
+		{"file", NewFileStorage(), `{"a":"a_data","b/c":"bc_data"}`, false},
+		{"temporary", NewTemporaryStorage(), `{"a":"a_data","b/c":"bc_data"}`, false},
+		{"nil", nil, `{}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			m := NewManagerWithStorage(tt.storage)
+			switch tt.storage.(type) {
+			case *FileStorage:
+				tt.storage.(*FileStorage).SetStorePath("./tests/workdir/Manager_DumpJSON_LoadJSON")
+			}
+
+			// test
+			if err := m.LoadJSON(tt.input); (err != nil) != tt.wantErr {
+				t.Errorf("LoadJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := m.DumpJSON()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DumpJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.input {
+				t.Errorf("DumpJSON() = %v, want %v", got, tt.input)
+			}
+
+			// cleanup test
+			switch tt.storage.(type) {
+			case *FileStorage:
+				err = os.RemoveAll(tt.storage.(*FileStorage).GetStorePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_WriteToDisk_ReadFromDisk(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage Storage
+		wantErr bool
+	}{
+		{"file", NewFileStorage(), true},
+		{"temporary", NewTemporaryStorage(), false},
+	}
+	path := "./tests/workdir/Manager_WriteToDisk_ReadFromDisk"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			m := NewManagerWithStorage(tt.storage)
+			err := os.MkdirAll(path, storageDirMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// test
+			if err = m.WriteToDisk(path); (err != nil) != tt.wantErr {
+				t.Errorf("WriteToDisk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err = m.ReadFromDisk(path); (err != nil) != tt.wantErr {
+				t.Errorf("ReadFromDisk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// cleanup test
+			err = os.RemoveAll(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
 func TestManager_EnableRecovery(t *testing.T) {
 	type args struct {
 		key string
@@ -39,12 +212,9 @@ func TestManager_EnableRecovery(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := NewManager()
-			if len(m.recoveryKeyBytes) != 0 {
+			if m.recoveryKey.Len() != 0 {
 				t.Fatalf("NewManager() should not have any recovery key")
 			}
-			if len(m.recoveryKeySecret) != 0 {
-				t.Fatalf("NewManager() should not have any recovery secret")
-			}
 
 			m.withRecovery = false
 			m.EnableRecovery(tt.args.key)
@@ -52,11 +222,8 @@ func TestManager_EnableRecovery(t *testing.T) {
 			if !m.withRecovery {
 				t.Fatalf("manager should have recovery enabled")
 			}
-			if len(m.recoveryKeyBytes) == 0 {
-				t.Errorf("wrong recovery key length = %v", len(m.recoveryKeyBytes))
-			}
-			if len(m.recoveryKeySecret) == 0 {
-				t.Errorf("wrong recovery secret length = %v", len(m.recoveryKeySecret))
+			if m.recoveryKey.Len() != len(tt.args.key) {
+				t.Errorf("wrong recovery key length = %v", m.recoveryKey.Len())
 			}
 		})
 	}
@@ -72,23 +239,24 @@ func TestManager_DisableRecovery(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			m := NewManager()
 
-			m.withRecovery = true
+			m.EnableRecovery("123456")
+			memory := m.recoveryKey.memory
 			m.DisableRecovery()
 
 			if m.withRecovery {
 				t.Fatalf("manager should not have recovery enabled")
 			}
-			if len(m.recoveryKeyBytes) != 0 {
-				t.Errorf("wrong recovery key length = %v", len(m.recoveryKeyBytes))
+			if m.recoveryKey.Len() != 0 {
+				t.Errorf("wrong recovery key length = %v", m.recoveryKey.Len())
 			}
-			if len(m.recoveryKeySecret) != 0 {
-				t.Errorf("wrong recovery secret length = %v", len(m.recoveryKeySecret))
+			if !memory.freed || memory.heap && !bytes.Equal(memory.data, make([]byte, len(memory.data))) {
+				t.Errorf("DisableRecovery() did not wipe the recovery key")
 			}
 		})
 	}
 }
 
-func TestManager_getRecoveryKey(t *testing.T) {
+func TestManager_useRecoveryKey(t *testing.T) {
 	tests := []struct {
 		name string
 		want string
@@ -99,9 +267,11 @@ func TestManager_getRecoveryKey(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			m := NewManager()
 			m.EnableRecovery(tt.want)
-			if got := m.getRecoveryKey(); got != tt.want {
-				t.Errorf("getRecoveryKey() = %v, want %v", got, tt.want)
-			}
+			m.useRecoveryKey(func(got string) {
+				if got != tt.want {
+					t.Errorf("useRecoveryKey() = %v, want %v", got, tt.want)
+				}
+			})
 		})
 	}
 }
@@ -213,6 +383,146 @@ func TestManager_Get(t *testing.T) {
 	}
 }
 
+func TestManager_GetEntry_SetLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		labels     map[string]string
+		key        string
+		wantLabels map[string]string
+		wantErr    error
+	}{
+		{"no labels", "foo", nil, "456", nil, nil},
+		{"set labels", "foo", map[string]string{"owner": "me"}, "456", map[string]string{"owner": "me"}, nil},
+		{"replace labels", "Foo", map[string]string{"env": "prod"}, "456", map[string]string{"env": "prod"}, nil},
+		{"clear labels", "foo", map[string]string{}, "456", nil, nil},
+		{"invalid key", "foo", map[string]string{"owner": "me"}, "wrong", nil, AuthenticationErr},
+		{"invalid id", "bar", map[string]string{"owner": "me"}, "456", nil, NotFoundErr},
+	}
+	// init
+	oldHash := Hash
+	Hash = sha256Hash
+	m := NewManagerWithStorage(NewTemporaryStorage())
+
+	err := m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Created.Equal(first.Modified) {
+		t.Errorf("GetEntry() created = %v, modified = %v, want equal", first.Created, first.Modified)
+	}
+
+	// tests
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.labels != nil {
+				err := m.SetLabels(tt.id, tt.labels, tt.key)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SetLabels() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+
+			got, err := m.GetEntry(tt.id, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Id != "foo" || got.Password != "123" {
+				t.Errorf("GetEntry() got = %v, %v, want foo, 123", got.Id, got.Password)
+			}
+			if !got.Created.Equal(first.Created) || !got.Modified.Equal(first.Modified) {
+				t.Errorf("GetEntry() timestamps changed: %v, %v", got.Created, got.Modified)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+				t.Errorf("GetEntry() labels = %v, want %v", got.Labels, tt.wantLabels)
+			}
+		})
+	}
+
+	// overwrite keeps creation time and labels
+	err = m.SetLabels("foo", map[string]string{"owner": "me"}, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	err = m.Set("foo", "123", "789", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Password != "789" {
+		t.Errorf("GetEntry() password = %v, want 789", got.Password)
+	}
+	if !got.Created.Equal(first.Created) {
+		t.Errorf("GetEntry() created = %v, want %v", got.Created, first.Created)
+	}
+	if !got.Modified.After(first.Modified) {
+		t.Errorf("GetEntry() modified = %v, want after %v", got.Modified, first.Modified)
+	}
+	if !reflect.DeepEqual(got.Labels, map[string]string{"owner": "me"}) {
+		t.Errorf("GetEntry() labels = %v, want owner=me", got.Labels)
+	}
+
+	// overwriting an expired entry keeps its labels
+	err = m.OverwriteWithExpiry("foo", "789", "456", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("foo", "abc", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.GetEntry("foo", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(first.Created) || !reflect.DeepEqual(got.Labels, map[string]string{"owner": "me"}) {
+		t.Errorf("GetEntry() got = %v, %v, want %v, owner=me", got.Created, got.Labels, first.Created)
+	}
+
+	// corrupt and mismatched entries are replaced
+	err = m.GetStorage().Store("baz", "corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("baz", "123", "456")
+	if err != nil {
+		t.Errorf("Overwrite() of corrupt entry error = %v", err)
+	}
+	got, err = m.GetEntry("baz", "456")
+	if err != nil || got.Password != "123" || len(got.Labels) != 0 {
+		t.Errorf("GetEntry() = %v, %v, want 123 without labels", got, err)
+	}
+	stored, err := m.GetStorage().Retrieve("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.GetStorage().Store("baz", stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Overwrite("baz", "789", "456")
+	if err != nil {
+		t.Errorf("Overwrite() of mismatched entry error = %v", err)
+	}
+	got, err = m.GetEntry("baz", "456")
+	if err != nil || got.Password != "789" || len(got.Labels) != 0 {
+		t.Errorf("GetEntry() = %v, %v, want 789 without labels", got, err)
+	}
+
+	// cleanup
+	Hash = oldHash
+}
+
 func TestManager_Check(t *testing.T) {
 	type args struct {
 		id       string
@@ -352,6 +662,112 @@ func TestManager_Set(t *testing.T) {
 	}
 }
 
+func TestManager_Set_concurrent(t *testing.T) {
+	tests := []struct {
+		name       string
+		backend    Storage
+		goroutines int
+		increments int
+	}{
+		{"FileStorage", NewFileStorage(), 16, 10},
+		{"TemporaryStorage", NewTemporaryStorage(), 32, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(tt.backend)
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_Set_concurrent")
+			}
+
+			err := m.Overwrite("counter", "0", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// test: every goroutine increments the stored counter via compare-and-set
+			var wg sync.WaitGroup
+			for i := 0; i < tt.goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < tt.increments; {
+						current, err := m.Get("counter", "456")
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						value, err := strconv.Atoi(current)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						err = m.Set("counter", current, strconv.Itoa(value+1), "456")
+						if errors.Is(err, IncorrectPasswordErr) {
+							// lost the race, retry with fresh value
+							continue
+						}
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						j++
+					}
+				}()
+			}
+			wg.Wait()
+
+			got, err := m.Get("counter", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strconv.Itoa(tt.goroutines * tt.increments); got != want {
+				t.Errorf("Get() got = %v, want %v", got, want)
+			}
+
+			// test: only one of many concurrent writers with the same old password wins
+			var successes int
+			var successMutex sync.Mutex
+			for i := 0; i < tt.goroutines; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := m.Set("counter", got, "winner"+strconv.Itoa(i), "456")
+					if err == nil {
+						successMutex.Lock()
+						successes++
+						successMutex.Unlock()
+					} else if !errors.Is(err, IncorrectPasswordErr) {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if successes != 1 {
+				t.Errorf("Set() successes = %v, want 1", successes)
+			}
+			if len(m.idTree) != 0 || len(m.idTreeLockCount) != 0 {
+				t.Errorf("idTree not cleaned up: %v, %v", m.idTree, m.idTreeLockCount)
+			}
+
+			// cleanup test
+			Hash = oldHash
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestManager_Unset(t *testing.T) {
 	type args struct {
 		id       string
@@ -529,6 +945,108 @@ func TestManager_List(t *testing.T) {
 	}
 }
 
+func TestManager_ListPasswords(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableRecovery("rec")
+	m.EnableHistory(2)
+	for _, id := range []string{"c", "a", "b/foo"} {
+		err := m.Overwrite(id, "123", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := m.Set("a", "123", "789", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.storageBackend.Delete("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test
+	passwords, orphaned, err := m.ListPasswords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(passwords, []string{"a", "b/foo"}) {
+		t.Errorf("ListPasswords() passwords = %v", passwords)
+	}
+	if !reflect.DeepEqual(orphaned, []string{"c" + RecoveryIdSuffix}) {
+		t.Errorf("ListPasswords() orphaned = %v", orphaned)
+	}
+
+	// deleting a missing password removes its orphaned recovery entry
+	err = m.Delete("c")
+	if !errors.Is(err, NotFoundErr) {
+		t.Errorf("Delete() error = %v, want %v", err, NotFoundErr)
+	}
+	_, orphaned, err = m.ListPasswords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("ListPasswords() orphaned = %v, want empty slice", orphaned)
+	}
+}
+
+func TestManager_Delete_recovery(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	m.EnableRecovery("rec")
+	m.EnableHistory(2)
+	for _, id := range []string{"a", "b", "c"} {
+		err := m.Overwrite(id, "123", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Set(id, "123", "789", "456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tests
+	tests := []struct {
+		name   string
+		delete func() error
+		id     string
+	}{
+		{"delete", func() error { return m.Delete("a") }, "a"},
+		{"unset", func() error { return m.Unset("b", "789", "456") }, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.delete()
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err := m.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range list {
+				if id == tt.id || strings.HasPrefix(id, tt.id+".") {
+					t.Errorf("%v left %v behind", tt.name, id)
+				}
+			}
+		})
+	}
+
+	// the recovery entry itself can be deleted alone
+	err := m.Delete("c" + RecoveryIdSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Get("c", "456")
+	if err != nil || got != "789" {
+		t.Errorf("Get() after deleting the recovery entry = %v, %v", got, err)
+	}
+}
+
 func TestManager_Delete(t *testing.T) {
 	type args struct {
 		id string
@@ -746,3 +1264,88 @@ func TestManager_RewriteKey(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestManager_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Storage
+		run     func(m *Manager) error
+		wantErr error
+	}{
+		{"file not found", NewFileStorage(), func(m *Manager) error {
+			_, err := m.Get("missing", "456")
+			return err
+		}, NotFoundErr},
+		{"temporary not found", NewTemporaryStorage(), func(m *Manager) error {
+			_, err := m.Get("missing", "456")
+			return err
+		}, NotFoundErr},
+		{"file delete not found", NewFileStorage(), func(m *Manager) error {
+			return m.Delete("missing")
+		}, NotFoundErr},
+		{"temporary delete not found", NewTemporaryStorage(), func(m *Manager) error {
+			return m.Delete("missing")
+		}, NotFoundErr},
+		{"wrong key", NewTemporaryStorage(), func(m *Manager) error {
+			_, err := m.Get("foo", "wrong")
+			return err
+		}, AuthenticationErr},
+		{"incorrect password set", NewTemporaryStorage(), func(m *Manager) error {
+			return m.Set("foo", "wrong", "789", "456")
+		}, IncorrectPasswordErr},
+		{"incorrect password unset", NewTemporaryStorage(), func(m *Manager) error {
+			return m.Unset("foo", "wrong", "456")
+		}, IncorrectPasswordErr},
+		{"id mismatch", NewTemporaryStorage(), func(m *Manager) error {
+			data, err := m.storageBackend.Retrieve("foo")
+			if err != nil {
+				return err
+			}
+			err = m.storageBackend.Store("bar", data)
+			if err != nil {
+				return err
+			}
+			_, err = m.Get("bar", "456")
+			return err
+		}, IdMismatchErr},
+		{"corrupt entry", NewTemporaryStorage(), func(m *Manager) error {
+			err := m.storageBackend.Store("bar", "not base64")
+			if err != nil {
+				return err
+			}
+			_, err = m.Get("bar", "456")
+			return err
+		}, CorruptEntryErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			m := NewManager()
+			m.storageBackend = tt.backend
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_Errors")
+			}
+
+			err := m.Overwrite("foo", "123", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// test
+			if err = tt.run(m); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			// cleanup test
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package password
+
+import (
+	"github.com/image357/password/log"
+	"strings"
+)
+
+// Migrate re-encrypts all entries whose ciphertext header differs from the current format of the manager in place.
+// This upgrades legacy entries without a header and entries with an outdated cipher (see SetCipher) or key derivation parameters.
+// storageKeyForIds returns the storage key of a password id. Versions use the key of their password,
+// and recovery entries use the recovery key if recovery is enabled. In envelope encrypted stores, storageKeyForIds is
+// also called with KeyringId and must return the master key (see EnableEnvelopeEncryption). Envelope entries themselves
+// are not migrated, since their data keys are random. Entries that cannot be decrypted are skipped with a warning.
+// The hash function of the manager must still match legacy entries. It returns the migrated ids.
+func (m *Manager) Migrate(storageKeyForIds func(id string) string) ([]string, error) {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]string, 0)
+	for _, id := range ids {
+		var ok bool
+		switch {
+		case strings.HasSuffix(id, RecoveryIdSuffix):
+			// public key recovery entries cannot be decrypted by the manager
+			if !m.withRecovery || m.hasRecoveryPublicKey() {
+				continue
+			}
+			ok, err = m.migrateRecoveryId(id, strings.TrimSuffix(id, RecoveryIdSuffix))
+		case id == KeyringId:
+			// the keyring is encrypted with the master key of the store
+			ok, err = m.migrateId(id, id, storageKeyForIds(KeyringId))
+		case isHistoryId(id):
+			owner := historyOwner(id)
+			ok, err = m.migrateId(id, owner, storageKeyForIds(owner))
+		default:
+			ok, err = m.migrateId(id, id, storageKeyForIds(id))
+		}
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated = append(migrated, id)
+		}
+	}
+
+	return migrated, nil
+}
+
+// migrateId re-encrypts a single entry with the current format of the manager while holding the lock of owner.
+func (m *Manager) migrateId(id string, owner string, key string) (bool, error) {
+	m.lockId(owner)
+	defer m.unlockId(owner)
+
+	return m.migrateEntry(id, key)
+}
+
+// migrateRecoveryId re-encrypts a recovery entry with the recovery key while holding the lock of owner.
+// The recovery key is borrowed after the id lock is held, i.e. in the same lock order as writeRecovery.
+func (m *Manager) migrateRecoveryId(id string, owner string) (bool, error) {
+	m.lockId(owner)
+	defer m.unlockId(owner)
+
+	var ok bool
+	var err error
+	useErr := m.useRecoveryKey(func(recoveryKey string) {
+		ok, err = m.migrateEntry(id, recoveryKey)
+	})
+	if useErr != nil {
+		return false, useErr
+	}
+	return ok, err
+}
+
+// migrateEntry re-encrypts a single entry with the current format of the manager. The owner of id must be locked.
+func (m *Manager) migrateEntry(id string, key string) (bool, error) {
+	encryptedData, err := m.storageBackend.Retrieve(id)
+	if err != nil {
+		return false, err
+	}
+
+	// envelope entries and entries with key slots encrypt their data with random data keys
+	if isEnvelope(encryptedData) || isSlotted(encryptedData) {
+		return false, nil
+	}
+
+	header, err := ParseHeader(encryptedData)
+	if err != nil {
+		log.Warn("cannot migrate entry", "id", id, "error", err)
+		return false, nil
+	}
+	if header == m.header() {
+		return false, nil
+	}
+
+	packedData, err := m.decryptWithKey(encryptedData, key)
+	if err != nil {
+		log.Warn("cannot migrate entry", "id", id, "error", err)
+		return false, nil
+	}
+
+	newData, err := m.encryptWithKey(packedData, key)
+	if err != nil {
+		return false, err
+	}
+
+	err = m.storageBackend.Store(id, newData)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -2,6 +2,8 @@
 // For full documentation visit https://github.com/image357/password/blob/main/docs/password.md
 package password
 
+import "time"
+
 // Managers stores a map of string identifiers for all created password managers.
 // The identifier "default" always holds the default manager from GetDefaultManager.
 // It can be set via SetDefaultManager. Do not manipulate directly.
@@ -39,28 +41,171 @@ func DisableHashing() {
 	m.HashPassword = false
 }
 
+// SetHashParams sets the argon2id cost parameters of new password hashes of the default manager.
+// Check transparently rehashes passwords with weaker parameters or legacy hashes.
+func SetHashParams(params Argon2Params) error {
+	return GetDefaultManager().SetHashParams(params)
+}
+
+// SetKDFParams sets the argon2i parameters of storage key derivation for new entries of the default manager.
+func SetKDFParams(params Argon2Params) error {
+	return GetDefaultManager().SetKDFParams(params)
+}
+
+// SetHashFunc sets a custom hash function of the default manager, which replaces the global Hash function.
+func SetHashFunc(hash HashFunc) {
+	GetDefaultManager().SetHashFunc(hash)
+}
+
+// EnableKeyCache caches up to size derived storage keys of the default manager for ttl.
+func EnableKeyCache(size int, ttl time.Duration) error {
+	return GetDefaultManager().EnableKeyCache(size, ttl)
+}
+
+// DisableKeyCache wipes all cached keys of the default manager and disables the cache.
+func DisableKeyCache() {
+	GetDefaultManager().DisableKeyCache()
+}
+
+// AddKeySlot allows reading an existing entry of the default manager with newKey in addition to existingKey.
+func AddKeySlot(id string, existingKey string, newSlotName string, newKey string) error {
+	return GetDefaultManager().AddKeySlot(id, existingKey, newSlotName, newKey)
+}
+
+// RemoveKeySlot removes a key slot from an entry of the default manager.
+// key must unlock the removed slot or DefaultKeySlot.
+func RemoveKeySlot(id string, key string, slotName string) error {
+	return GetDefaultManager().RemoveKeySlot(id, key, slotName)
+}
+
+// ListKeySlots returns the key slot names of an entry of the default manager.
+func ListKeySlots(id string) ([]string, error) {
+	return GetDefaultManager().ListKeySlots(id)
+}
+
+// ImportHash stores a foreign password hash (bcrypt, scrypt, PBKDF2, sha-crypt) for an id of the default manager.
+// Hashing must be enabled (see EnableHashing). EntryExistsErr is returned if id holds a password that has not expired.
+func ImportHash(id string, encodedHash string, key string) error {
+	return GetDefaultManager().ImportHash(id, encodedHash, key)
+}
+
+// EnableHashUpgrade will replace imported foreign hashes with argon2id hashes after a successful Check.
+func EnableHashUpgrade() {
+	GetDefaultManager().EnableHashUpgrade()
+}
+
+// DisableHashUpgrade will keep imported foreign hashes after a successful Check.
+func DisableHashUpgrade() {
+	GetDefaultManager().DisableHashUpgrade()
+}
+
 // EnableRecovery will enforce recovery key file storage alongside passwords.
 func EnableRecovery(key string) {
 	GetDefaultManager().EnableRecovery(key)
 }
 
+// EnableSplitRecovery enables recovery of the default manager with a random recovery key,
+// which is split into shares such that any threshold of them reconstruct it.
+func EnableSplitRecovery(threshold int, shares int) ([]string, error) {
+	return GetDefaultManager().EnableSplitRecovery(threshold, shares)
+}
+
+// EnablePublicKeyRecovery enables recovery of the default manager, where storage keys are encrypted to a public key
+// of GenerateRecoveryKeyPair.
+func EnablePublicKeyRecovery(publicKey string) error {
+	return GetDefaultManager().EnablePublicKeyRecovery(publicKey)
+}
+
+// RecoverStorageKey returns the storage key of id from its public key recovery entry with the private key.
+func RecoverStorageKey(id string, privateKey string) (string, error) {
+	return GetDefaultManager().RecoverStorageKey(id, privateKey)
+}
+
+// BackfillRecovery writes recovery entries of the default manager for all passwords that lack them.
+func BackfillRecovery(storageKeyForIds func(id string) string) (RecoveryReport, error) {
+	return GetDefaultManager().BackfillRecovery(storageKeyForIds)
+}
+
+// RotateRecoveryKey re-encrypts all recovery entries of the default manager with a new recovery key.
+func RotateRecoveryKey(oldRecoveryKey string, newRecoveryKey string) error {
+	return GetDefaultManager().RotateRecoveryKey(oldRecoveryKey, newRecoveryKey)
+}
+
+// MissingRecovery returns the ids of all passwords of the default manager without a recovery entry.
+func MissingRecovery() ([]string, error) {
+	return GetDefaultManager().MissingRecovery()
+}
+
 // DisableRecovery will stop recovery key file storage alongside passwords.
 func DisableRecovery() {
 	GetDefaultManager().DisableRecovery()
 }
 
+// SetPasswordPolicy sets the policy that Set and Overwrite enforce on new passwords. nil disables the policy.
+func SetPasswordPolicy(policy PasswordPolicy) {
+	GetDefaultManager().SetPasswordPolicy(policy)
+}
+
+// SetCipher selects the authenticated cipher of new entries of the default manager.
+func SetCipher(id CipherId) error {
+	return GetDefaultManager().SetCipher(id)
+}
+
+// EnableEnvelopeEncryption will encrypt new entries with random per-entry data keys that are wrapped by the store keyring.
+func EnableEnvelopeEncryption() {
+	GetDefaultManager().EnableEnvelopeEncryption()
+}
+
+// DisableEnvelopeEncryption will encrypt new entries directly with the storage key again.
+func DisableEnvelopeEncryption() {
+	GetDefaultManager().DisableEnvelopeEncryption()
+}
+
+// RotateMasterKey changes the master key of the keyring from oldKey to newKey.
+func RotateMasterKey(oldKey string, newKey string) error {
+	return GetDefaultManager().RotateMasterKey(oldKey, newKey)
+}
+
+// EnableHistory will keep the last size encrypted versions of every password in the storage backend.
+func EnableHistory(size int) {
+	GetDefaultManager().EnableHistory(size)
+}
+
+// DisableHistory will stop recording new versions. Existing versions are kept until the password is deleted.
+func DisableHistory() {
+	GetDefaultManager().DisableHistory()
+}
+
 // Overwrite an existing password or create a new one.
 // key is the encryption secret for storage.
 func Overwrite(id string, password string, key string) error {
 	return GetDefaultManager().Overwrite(id, password, key)
 }
 
+// OverwriteWithExpiry overwrites an existing password or creates a new one that expires at the given time.
+// key is the encryption secret for storage.
+func OverwriteWithExpiry(id string, password string, key string, expires time.Time) error {
+	return GetDefaultManager().OverwriteWithExpiry(id, password, key, expires)
+}
+
 // Get an existing password with id.
 // key is the encryption secret for storage.
 func Get(id string, key string) (string, error) {
 	return GetDefaultManager().Get(id, key)
 }
 
+// GetEntry returns an existing password with id together with its metadata.
+// key is the encryption secret for storage.
+func GetEntry(id string, key string) (Entry, error) {
+	return GetDefaultManager().GetEntry(id, key)
+}
+
+// SetLabels replaces the user-defined labels of an existing password.
+// key is the encryption secret for storage.
+func SetLabels(id string, labels map[string]string, key string) error {
+	return GetDefaultManager().SetLabels(id, labels, key)
+}
+
 // Check an existing password for equality with the provided password.
 // key is the encryption secret for storage.
 func Check(id string, password string, key string) (bool, error) {
@@ -74,6 +219,13 @@ func Set(id string, oldPassword string, newPassword string, key string) error {
 	return GetDefaultManager().Set(id, oldPassword, newPassword, key)
 }
 
+// SetWithExpiry sets an existing password-id or creates a new one that expires at the given time.
+// oldPassword must match the currently stored password.
+// key is the encryption secret for storage.
+func SetWithExpiry(id string, oldPassword string, newPassword string, key string, expires time.Time) error {
+	return GetDefaultManager().SetWithExpiry(id, oldPassword, newPassword, key, expires)
+}
+
 // Unset (delete) an existing password.
 // password must match the currently stored password.
 // key is the encryption secret for storage.
@@ -86,11 +238,17 @@ func Exists(id string) (bool, error) {
 	return GetDefaultManager().Exists(id)
 }
 
-// List all stored password-ids.
+// List all stored password-ids without versions and the keyring.
 func List() ([]string, error) {
 	return GetDefaultManager().List()
 }
 
+// ListPasswords returns the ids of all stored passwords without recovery entries, versions and the keyring,
+// and the ids of orphaned recovery entries.
+func ListPasswords() ([]string, []string, error) {
+	return GetDefaultManager().ListPasswords()
+}
+
 // Delete an existing password.
 func Delete(id string) error {
 	return GetDefaultManager().Delete(id)
@@ -107,3 +265,50 @@ func Clean() error {
 func RewriteKey(id string, oldKey string, newKey string) error {
 	return GetDefaultManager().RewriteKey(id, oldKey, newKey)
 }
+
+// RewriteAllKeys changes the storage key of all passwords from oldKey to newKey.
+// All entries are verified before writing and restored if any entry fails. progress may be nil.
+func RewriteAllKeys(oldKey string, newKey string, progress RewriteProgressFunc) error {
+	return GetDefaultManager().RewriteAllKeys(oldKey, newKey, progress)
+}
+
+// Migrate re-encrypts all entries with the current ciphertext format of Encrypt.
+// storageKeyForIds returns the storage key of a password id, and the master key for KeyringId in envelope encrypted stores.
+// It returns the migrated ids.
+func Migrate(storageKeyForIds func(id string) string) ([]string, error) {
+	return GetDefaultManager().Migrate(storageKeyForIds)
+}
+
+// ListVersions returns the ascending version numbers that are stored for a password.
+func ListVersions(id string) ([]int, error) {
+	return GetDefaultManager().ListVersions(id)
+}
+
+// GetVersion returns a previous version of a password together with its metadata.
+// key is the encryption secret the version was stored with.
+func GetVersion(id string, n int, key string) (Entry, error) {
+	return GetDefaultManager().GetVersion(id, n, key)
+}
+
+// Rollback restores a previous version of a password.
+// key is the encryption secret the version was stored with and will be the storage key afterward.
+func Rollback(id string, n int, key string) error {
+	return GetDefaultManager().Rollback(id, n, key)
+}
+
+// Reap purges all expired passwords together with their versions and recovery entries.
+// storageKeyForIds returns the storage key of an id.
+func Reap(storageKeyForIds func(id string) string) ([]string, error) {
+	return GetDefaultManager().Reap(storageKeyForIds)
+}
+
+// StartReaper starts a background goroutine that calls Reap every interval until StopReaper is called.
+// storageKeyForIds returns the storage key of an id. It returns ReaperIntervalErr if interval is not positive.
+func StartReaper(interval time.Duration, storageKeyForIds func(id string) string) error {
+	return GetDefaultManager().StartReaper(interval, storageKeyForIds)
+}
+
+// StopReaper stops the background goroutine of StartReaper and waits for it to finish.
+func StopReaper() {
+	GetDefaultManager().StopReaper()
+}
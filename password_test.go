@@ -1,9 +1,11 @@
 package password
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_SetDefaultManger_GetDefaultManager(t *testing.T) {
@@ -143,6 +145,15 @@ func Test_EnableRecovery_DisableRecovery(t *testing.T) {
 			if m.withRecovery {
 				t.Errorf("GetDefaultManager().withRecovery = true, want false")
 			}
+
+			shares, err := EnableSplitRecovery(2, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.withRecovery || len(shares) != 3 {
+				t.Errorf("EnableSplitRecovery() = %v shares, withRecovery = %v", len(shares), m.withRecovery)
+			}
+			DisableRecovery()
 		})
 	}
 }
@@ -162,6 +173,13 @@ func TestPassword_PublicAPI(t *testing.T) {
 	// tests
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			EnableHistory(2)
+			defer DisableHistory()
+			SetPasswordPolicy(RulePolicy{MinLength: 3})
+			defer SetPasswordPolicy(nil)
+			defer SetCipher(CipherAES256GCM)
+			defer func() { GetDefaultManager().kdf = KDFParams{} }()
+
 			err := Overwrite("foo", "123", "456")
 			if err != nil {
 				t.Fatal(err)
@@ -187,6 +205,63 @@ func TestPassword_PublicAPI(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			err = SetLabels("foo", map[string]string{"owner": "me"}, "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = GetEntry("foo", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Overwrite("foo", "abc", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versions, err := ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 1 {
+				t.Fatalf("ListVersions() = %v, want one version", versions)
+			}
+
+			_, err = GetVersion("foo", versions[0], "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Rollback("foo", versions[0], "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = OverwriteWithExpiry("token", "123", "456", time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = SetWithExpiry("token", "", "123", "456", time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = StartReaper(time.Hour, func(string) string { return "456" })
+			if err != nil {
+				t.Fatal(err)
+			}
+			StopReaper()
+
+			purged, err := Reap(func(string) string { return "456" })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(purged) != 1 {
+				t.Fatalf("Reap() = %v, want one id", purged)
+			}
+
 			err = Set("foo", "123", "789", "456")
 			if err != nil {
 				t.Fatal(err)
@@ -207,6 +282,45 @@ func TestPassword_PublicAPI(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			err = SetCipher(CipherXChaCha20Poly1305)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = SetHashParams(DefaultArgon2Params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = SetKDFParams(DefaultKDFParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetHashFunc(nil)
+
+			err = EnableKeyCache(16, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			DisableKeyCache()
+
+			EnableHashUpgrade()
+			DisableHashUpgrade()
+			err = ImportHash("foo", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "456")
+			if !errors.Is(err, HashingDisabledErr) {
+				t.Fatalf("ImportHash() error = %v, want %v", err, HashingDisabledErr)
+			}
+
+			_, err = Migrate(func(string) string { return "456" })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = RewriteAllKeys("456", "789", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
 			err = Delete("bar")
 			if err != nil {
 				t.Fatal(err)
@@ -0,0 +1,437 @@
+// Package passwordtest provides a conformance test suite for custom implementations of password.Storage.
+package passwordtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/image357/password"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// concurrencyWorkers controls the number of goroutines in concurrency tests.
+const concurrencyWorkers = 16
+
+// concurrencyRounds controls the number of operations per goroutine in concurrency tests.
+const concurrencyRounds = 25
+
+// StorageFactory is a callback signature.
+// The callback must return a new and empty storage backend for every call.
+// Use t.Cleanup to release any resources of the returned backend.
+type StorageFactory func(t *testing.T) password.Storage
+
+// RunStorageSuite runs the conformance test suite against storage backends created by factory.
+// The storage backend is always called with normalized ids, i.e. the result of password.NormalizeId.
+func RunStorageSuite(t *testing.T, factory StorageFactory) {
+	t.Run("Store", func(t *testing.T) { testStore(t, factory) })
+	t.Run("Retrieve", func(t *testing.T) { testRetrieve(t, factory) })
+	t.Run("Exists", func(t *testing.T) { testExists(t, factory) })
+	t.Run("List", func(t *testing.T) { testList(t, factory) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory) })
+	t.Run("Clean", func(t *testing.T) { testClean(t, factory) })
+	t.Run("DumpJSON", func(t *testing.T) { testDumpJSON(t, factory) })
+	t.Run("LoadJSON", func(t *testing.T) { testLoadJSON(t, factory) })
+	t.Run("NestedIds", func(t *testing.T) { testNestedIds(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+// mustStore fills s with the provided id to data map and fails the test on error.
+func mustStore(t *testing.T, s password.Storage, registry map[string]string) {
+	t.Helper()
+	for id, data := range registry {
+		err := s.Store(id, data)
+		if err != nil {
+			t.Fatalf("Store(%v) error = %v", id, err)
+		}
+	}
+}
+
+// contents returns all entries of s as an id to data map and fails the test on error.
+func contents(t *testing.T, s password.Storage) map[string]string {
+	t.Helper()
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	registry := make(map[string]string)
+	for _, id := range list {
+		data, err := s.Retrieve(id)
+		if err != nil {
+			t.Fatalf("Retrieve(%v) error = %v", id, err)
+		}
+		registry[id] = data
+	}
+	return registry
+}
+
+func testStore(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name string
+		id   string
+		data string
+	}{
+		{"create", "some/id", "some data"},
+		{"overwrite", "some/id", "another data"},
+		{"create another", "another/id", "another data"},
+		{"empty data", "empty", ""},
+		{"unicode data", "unicode", "äöü€<>&"},
+	}
+	s := factory(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Store(tt.id, tt.data); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+			got, err := s.Retrieve(tt.id)
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if got != tt.data {
+				t.Errorf("Retrieve() got = %v, want %v", got, tt.data)
+			}
+		})
+	}
+}
+
+func testRetrieve(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name    string
+		id      string
+		want    string
+		wantErr error
+	}{
+		{"some id", "some/id", "some data", nil},
+		{"another id", "another/id", "another data", nil},
+		{"missing id", "missing/id", "", password.NotFoundErr},
+	}
+	s := factory(t)
+	mustStore(t, s, map[string]string{"some/id": "some data", "another/id": "another data"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Retrieve(tt.id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Retrieve() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Retrieve() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testExists(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"some id", "some/id", true},
+		{"missing id", "missing/id", false},
+		{"parent of id", "some", false},
+	}
+	s := factory(t)
+	mustStore(t, s, map[string]string{"some/id": "some data"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Exists(tt.id)
+			if err != nil {
+				t.Fatalf("Exists() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Exists() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testList(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{"empty", []string{}, []string{}},
+		{"single", []string{"filename"}, []string{"filename"}},
+		{"sorted", []string{"c", "a", "b"}, []string{"a", "b", "c"}},
+		{"nested", []string{"c/bar", "a/foo", "b/baz", "a"}, []string{"a", "a/foo", "b/baz", "c/bar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := factory(t)
+			for _, id := range tt.ids {
+				mustStore(t, s, map[string]string{id: "123"})
+			}
+			got, err := s.List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if got == nil {
+				t.Fatalf("List() got = nil, want non-nil slice")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testDelete(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{"some id", "some/id", nil},
+		{"deleted id", "some/id", password.NotFoundErr},
+		{"missing id", "missing/id", password.NotFoundErr},
+	}
+	s := factory(t)
+	mustStore(t, s, map[string]string{"some/id": "some data", "another/id": "another data"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Delete(tt.id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Delete() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			exists, err := s.Exists(tt.id)
+			if err != nil {
+				t.Fatalf("Exists() error = %v", err)
+			}
+			if exists {
+				t.Errorf("Exists() got = %v after Delete()", exists)
+			}
+		})
+	}
+
+	want := map[string]string{"another/id": "another data"}
+	if got := contents(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("storage contents = %v, want %v", got, want)
+	}
+}
+
+func testClean(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name string
+	}{
+		{"normal"},
+		{"empty"},
+	}
+	s := factory(t)
+	mustStore(t, s, map[string]string{"some/id": "some data", "another_id": "another data"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Clean(); err != nil {
+				t.Fatalf("Clean() error = %v", err)
+			}
+			list, err := s.List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(list) != 0 {
+				t.Errorf("List() got = %v, want empty", list)
+			}
+		})
+	}
+}
+
+func testDumpJSON(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name     string
+		registry map[string]string
+	}{
+		{"empty", map[string]string{}},
+		{"flat", map[string]string{"a": "a_data", "b": "b_data"}},
+		{"nested", map[string]string{"a": "a_data", "b/c": "bc_data"}},
+		{"no escape", map[string]string{"a": "<>&"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := factory(t)
+			mustStore(t, s, tt.registry)
+
+			got, err := s.DumpJSON()
+			if err != nil {
+				t.Fatalf("DumpJSON() error = %v", err)
+			}
+
+			decoded := make(map[string]string)
+			err = json.Unmarshal([]byte(got), &decoded)
+			if err != nil {
+				t.Fatalf("DumpJSON() returned invalid JSON %v: %v", got, err)
+			}
+			if !reflect.DeepEqual(decoded, tt.registry) {
+				t.Errorf("DumpJSON() got = %v, want %v", decoded, tt.registry)
+			}
+
+			// reload into another backend
+			other := factory(t)
+			err = other.LoadJSON(got)
+			if err != nil {
+				t.Fatalf("LoadJSON() error = %v", err)
+			}
+			if reloaded := contents(t, other); !reflect.DeepEqual(reloaded, tt.registry) {
+				t.Errorf("LoadJSON(DumpJSON()) contents = %v, want %v", reloaded, tt.registry)
+			}
+		})
+	}
+}
+
+func testLoadJSON(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"merge",
+			`{"a":"a_data","b/c":"bc_data","d":"d_data"}`,
+			map[string]string{"a": "a_data", "b/c": "bc_data", "d": "d_data", "e": "old_data"},
+			false,
+		},
+		{"empty",
+			`{}`,
+			map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"},
+			false,
+		},
+		{"wrong type",
+			`{"a":"a_data","b/c":"bc_data","d":123}`,
+			map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"},
+			true,
+		},
+		{"nested object",
+			`{"a":"a_data","b":{"c":"bc_data"}}`,
+			map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"},
+			true,
+		},
+		{"invalid json",
+			`{"a":"a_data"`,
+			map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"},
+			true,
+		},
+		{"no object",
+			`["a"]`,
+			map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := factory(t)
+			mustStore(t, s, map[string]string{"a": "old_data", "b/c": "old_data", "e": "old_data"})
+
+			if err := s.LoadJSON(tt.input); (err != nil) != tt.wantErr {
+				t.Errorf("LoadJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := contents(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("storage contents = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testNestedIds(t *testing.T, factory StorageFactory) {
+	s := factory(t)
+	registry := map[string]string{
+		"a":       "a_data",
+		"a/b":     "ab_data",
+		"a/b/c":   "abc_data",
+		"a/b/c/d": "abcd_data",
+		"x/y/z":   "xyz_data",
+	}
+	mustStore(t, s, registry)
+
+	if got := contents(t, s); !reflect.DeepEqual(got, registry) {
+		t.Fatalf("storage contents = %v, want %v", got, registry)
+	}
+
+	// delete intermediate levels
+	for _, id := range []string{"a/b", "a"} {
+		err := s.Delete(id)
+		if err != nil {
+			t.Fatalf("Delete(%v) error = %v", id, err)
+		}
+		delete(registry, id)
+	}
+	if got := contents(t, s); !reflect.DeepEqual(got, registry) {
+		t.Errorf("storage contents = %v, want %v", got, registry)
+	}
+}
+
+func testConcurrency(t *testing.T, factory StorageFactory) {
+	s := factory(t)
+	const sharedId = "shared/id"
+
+	// all values written to the shared id
+	valid := make(map[string]bool)
+	for w := 0; w < concurrencyWorkers; w++ {
+		for r := 0; r < concurrencyRounds; r++ {
+			valid[fmt.Sprintf("worker %02d round %03d", w, r)] = true
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrencyWorkers*concurrencyRounds*4)
+	for w := 0; w < concurrencyWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ownId := fmt.Sprintf("worker/%02d", w)
+			for r := 0; r < concurrencyRounds; r++ {
+				data := fmt.Sprintf("worker %02d round %03d", w, r)
+
+				err := s.Store(sharedId, data)
+				if err != nil {
+					errs <- fmt.Errorf("Store(%v) error = %w", sharedId, err)
+				}
+
+				got, err := s.Retrieve(sharedId)
+				if err != nil {
+					errs <- fmt.Errorf("Retrieve(%v) error = %w", sharedId, err)
+				} else if !valid[got] {
+					errs <- fmt.Errorf("Retrieve(%v) got torn data %q", sharedId, got)
+				}
+
+				err = s.Store(ownId, data)
+				if err != nil {
+					errs <- fmt.Errorf("Store(%v) error = %w", ownId, err)
+				}
+
+				got, err = s.Retrieve(ownId)
+				if err != nil {
+					errs <- fmt.Errorf("Retrieve(%v) error = %w", ownId, err)
+				} else if got != data {
+					errs <- fmt.Errorf("Retrieve(%v) got = %v, want %v", ownId, got, data)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != concurrencyWorkers+1 {
+		t.Errorf("len(List()) = %v, want %v", len(list), concurrencyWorkers+1)
+	}
+}
@@ -0,0 +1,30 @@
+package passwordtest
+
+import (
+	"github.com/image357/password"
+	"testing"
+)
+
+func ExampleRunStorageSuite() {
+	// Run the conformance test suite from any test function of your storage backend, e.g.:
+	//
+	// func TestMyStorage(t *testing.T) {
+	//     passwordtest.RunStorageSuite(t, func(t *testing.T) password.Storage {
+	//         return NewMyStorage()
+	//     })
+	// }
+}
+
+func TestFileStorage(t *testing.T) {
+	RunStorageSuite(t, func(t *testing.T) password.Storage {
+		f := password.NewFileStorage()
+		f.SetStorePath(t.TempDir())
+		return f
+	})
+}
+
+func TestTemporaryStorage(t *testing.T) {
+	RunStorageSuite(t, func(t *testing.T) password.Storage {
+		return password.NewTemporaryStorage()
+	})
+}
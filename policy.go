@@ -0,0 +1,288 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	pathlib "path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PolicyViolationErr is wrapped by PolicyError if a new password does not satisfy the password policy of a Manager.
+var PolicyViolationErr = errors.New("password policy violation")
+
+// Rule names that are reported by RulePolicy and Manager.
+const (
+	RuleMinLength          = "min_length"
+	RuleMaxLength          = "max_length"
+	RuleLowercase          = "lowercase"
+	RuleUppercase          = "uppercase"
+	RuleDigit              = "digit"
+	RuleSymbol             = "symbol"
+	RuleForbiddenSubstring = "forbidden_substring"
+	RuleContainsId         = "contains_id"
+	RuleEntropy            = "entropy"
+	RuleReuse              = "reuse"
+)
+
+// minForbiddenIdLength is the minimum length of ids and path elements that RulePolicy.ForbidId checks.
+// Shorter ones, e.g. "a", would reject almost every password.
+const minForbiddenIdLength = 3
+
+// PolicyViolation describes a single rule that a password does not satisfy.
+type PolicyViolation struct {
+	// Rule is the machine-readable name of the rule, e.g. RuleMinLength.
+	Rule string `json:"rule"`
+
+	// Message is a human-readable reason.
+	Message string `json:"message"`
+}
+
+// PolicyError is returned by Manager.Set and Manager.Overwrite if a password violates the password policy.
+// It wraps PolicyViolationErr and lists all violations.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+// Error returns all violation messages.
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return fmt.Sprintf("%v: %v", PolicyViolationErr, strings.Join(messages, "; "))
+}
+
+// Unwrap returns PolicyViolationErr.
+func (e *PolicyError) Unwrap() error {
+	return PolicyViolationErr
+}
+
+// PasswordPolicy validates new passwords before they are stored by Manager.Set and Manager.Overwrite.
+// Implement it to plug custom rules into a Manager.
+type PasswordPolicy interface {
+	// Validate returns all violations of password for the normalized id. An empty result accepts the password.
+	Validate(id string, password string) []PolicyViolation
+}
+
+// containsId reports whether a lowercase password contains id, if id is long enough to be checked.
+func containsId(lowerPassword string, id string) bool {
+	return utf8.RuneCountInString(id) >= minForbiddenIdLength && strings.Contains(lowerPassword, strings.ToLower(id))
+}
+
+// ReusePolicy can be implemented by a PasswordPolicy to reject the reuse of previous passwords.
+// The Manager compares new passwords against the current password and stored versions (see Manager.EnableHistory).
+type ReusePolicy interface {
+	// ReuseLimit returns the number of most recent passwords (including the current one) that must not be reused.
+	ReuseLimit() int
+}
+
+// RulePolicy is a configurable PasswordPolicy. Zero values disable the corresponding rule.
+type RulePolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+
+	// MaxLength is the maximum number of characters.
+	MaxLength int
+
+	// RequireLowercase demands at least one lowercase letter.
+	RequireLowercase bool
+
+	// RequireUppercase demands at least one uppercase letter.
+	RequireUppercase bool
+
+	// RequireDigit demands at least one digit.
+	RequireDigit bool
+
+	// RequireSymbol demands at least one character that is neither a letter nor a digit.
+	RequireSymbol bool
+
+	// ForbiddenSubstrings lists case-insensitive substrings that must not occur in a password.
+	ForbiddenSubstrings []string
+
+	// ForbidId rejects passwords that contain the id or its last path element (case-insensitive).
+	// Ids and path elements with less than 3 characters are ignored.
+	ForbidId bool
+
+	// MinEntropy is the minimum estimated entropy in bits, see EstimateEntropy.
+	MinEntropy float64
+
+	// RejectReuse is the number of most recent passwords (including the current one) that must not be reused.
+	RejectReuse int
+}
+
+// Validate returns all violations of password for the normalized id.
+func (p RulePolicy) Validate(id string, password string) []PolicyViolation {
+	violations := make([]PolicyViolation, 0)
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PolicyViolation{RuleMinLength, fmt.Sprintf("password must have at least %v characters", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PolicyViolation{RuleMaxLength, fmt.Sprintf("password must have at most %v characters", p.MaxLength)})
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if p.RequireLowercase && !lower {
+		violations = append(violations, PolicyViolation{RuleLowercase, "password must contain a lowercase letter"})
+	}
+	if p.RequireUppercase && !upper {
+		violations = append(violations, PolicyViolation{RuleUppercase, "password must contain an uppercase letter"})
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, PolicyViolation{RuleDigit, "password must contain a digit"})
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, PolicyViolation{RuleSymbol, "password must contain a symbol"})
+	}
+
+	lowerPassword := strings.ToLower(password)
+	for _, s := range p.ForbiddenSubstrings {
+		if s != "" && strings.Contains(lowerPassword, strings.ToLower(s)) {
+			violations = append(violations, PolicyViolation{RuleForbiddenSubstring, fmt.Sprintf("password must not contain %q", s)})
+		}
+	}
+
+	if p.ForbidId && (containsId(lowerPassword, id) || containsId(lowerPassword, pathlib.Base(id))) {
+		violations = append(violations, PolicyViolation{RuleContainsId, "password must not contain its id"})
+	}
+
+	if p.MinEntropy > 0 {
+		entropy := EstimateEntropy(password)
+		if entropy < p.MinEntropy {
+			violations = append(violations, PolicyViolation{RuleEntropy, fmt.Sprintf("password entropy of %.1f bits is below %.1f bits", entropy, p.MinEntropy)})
+		}
+	}
+
+	return violations
+}
+
+// ReuseLimit returns RejectReuse.
+func (p RulePolicy) ReuseLimit() int {
+	return p.RejectReuse
+}
+
+// EstimateEntropy returns a rough estimate of the entropy of a password in bits.
+// It assumes that every character was chosen at random from the union of all character classes that occur in the password.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(utf8.RuneCountInString(password)) * math.Log2(float64(pool))
+}
+
+// SetPasswordPolicy sets the policy that Set and Overwrite enforce on new passwords. nil disables the policy.
+// Recovery entries and Rollback are not subject to the policy.
+func (m *Manager) SetPasswordPolicy(policy PasswordPolicy) {
+	m.passwordPolicy = policy
+}
+
+// GetPasswordPolicy returns the current password policy or nil.
+func (m *Manager) GetPasswordPolicy() PasswordPolicy {
+	return m.passwordPolicy
+}
+
+// checkPolicy validates a new password of a normalized id against the password policy.
+// key is used to compare against previous passwords if the policy implements ReusePolicy.
+func (m *Manager) checkPolicy(id string, password string, key string) error {
+	if m.passwordPolicy == nil || strings.HasSuffix(id, RecoveryIdSuffix) {
+		return nil
+	}
+
+	violations := m.passwordPolicy.Validate(id, password)
+
+	if p, ok := m.passwordPolicy.(ReusePolicy); ok && p.ReuseLimit() > 0 {
+		reused, err := m.isReused(id, password, key, p.ReuseLimit())
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, PolicyViolation{RuleReuse, fmt.Sprintf("password must not match the last %v passwords", p.ReuseLimit())})
+		}
+	}
+
+	if len(violations) != 0 {
+		return &PolicyError{violations}
+	}
+	return nil
+}
+
+// isReused compares a password with the current password and the most recent versions of a normalized id.
+// Entries that cannot be decrypted with key are ignored.
+func (m *Manager) isReused(id string, password string, key string, limit int) (bool, error) {
+	candidates := make([]Entry, 0, limit)
+
+	entry, err := m.getEntry(id, key)
+	if err == nil {
+		candidates = append(candidates, entry)
+	}
+
+	versions, err := m.listVersions(id)
+	if err != nil {
+		return false, err
+	}
+	for i := len(versions) - 1; i >= 0 && len(candidates) < limit; i-- {
+		entry, err := m.GetVersion(id, versions[i], key)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+
+	for _, c := range candidates[:min(len(candidates), limit)] {
+		equal, err := m.compare(c, password)
+		if err == nil && equal {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
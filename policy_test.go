@@ -0,0 +1,153 @@
+package password
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRulePolicy_Validate(t *testing.T) {
+	type args struct {
+		id       string
+		password string
+	}
+	tests := []struct {
+		name   string
+		policy RulePolicy
+		args   args
+		want   []string
+	}{
+		{"empty policy", RulePolicy{}, args{"foo", ""}, []string{}},
+		{"min length", RulePolicy{MinLength: 4}, args{"foo", "äbc"}, []string{RuleMinLength}},
+		{"max length", RulePolicy{MaxLength: 2}, args{"foo", "abc"}, []string{RuleMaxLength}},
+		{"classes ok", RulePolicy{RequireLowercase: true, RequireUppercase: true, RequireDigit: true, RequireSymbol: true}, args{"foo", "aB3!"}, []string{}},
+		{"classes missing", RulePolicy{RequireLowercase: true, RequireUppercase: true, RequireDigit: true, RequireSymbol: true}, args{"foo", ""}, []string{RuleLowercase, RuleUppercase, RuleDigit, RuleSymbol}},
+		{"forbidden substring", RulePolicy{ForbiddenSubstrings: []string{"Secret", ""}}, args{"foo", "mysecret1"}, []string{RuleForbiddenSubstring}},
+		{"contains id", RulePolicy{ForbidId: true}, args{"app/sql", "mySQL123"}, []string{RuleContainsId}},
+		{"contains full id", RulePolicy{ForbidId: true}, args{"a/b", "xa/by"}, []string{RuleContainsId}},
+		{"root id", RulePolicy{ForbidId: true}, args{".", "abc."}, []string{}},
+		{"short id", RulePolicy{ForbidId: true}, args{"a", "banana"}, []string{}},
+		{"short path element", RulePolicy{ForbidId: true}, args{"app/db", "myDB123"}, []string{}},
+		{"low entropy", RulePolicy{MinEntropy: 40}, args{"foo", "aaaaaa"}, []string{RuleEntropy}},
+		{"high entropy", RulePolicy{MinEntropy: 40}, args{"foo", "aB3!xY7?"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := tt.policy.Validate(tt.args.id, tt.args.password)
+			got := make([]string, len(violations))
+			for i, v := range violations {
+				got[i] = v.Rule
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		min      float64
+		max      float64
+	}{
+		{"empty", "", 0, 0},
+		{"digits", "1234", 13, 14},
+		{"lower", "abcd", 18, 19},
+		{"mixed", "aB3!", 26, 27},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateEntropy(tt.password); got < tt.min || got > tt.max {
+				t.Errorf("EstimateEntropy() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestManager_PasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		run       func(m *Manager) error
+		wantErr   error
+		wantRules []string
+	}{
+		{"overwrite ok", func(m *Manager) error {
+			return m.Overwrite("foo", "abcdef", "456")
+		}, nil, nil},
+		{"overwrite too short", func(m *Manager) error {
+			return m.Overwrite("foo", "abc", "456")
+		}, PolicyViolationErr, []string{RuleMinLength}},
+		{"set too short", func(m *Manager) error {
+			return m.Set("foo", "123456", "abc", "456")
+		}, PolicyViolationErr, []string{RuleMinLength}},
+		{"set wrong password first", func(m *Manager) error {
+			return m.Set("foo", "wrong", "abc", "456")
+		}, IncorrectPasswordErr, nil},
+		{"set reuse current", func(m *Manager) error {
+			return m.Set("foo", "123456", "123456", "456")
+		}, PolicyViolationErr, []string{RuleReuse}},
+		{"set reuse version", func(m *Manager) error {
+			err := m.Set("foo", "123456", "abcdef", "456")
+			if err != nil {
+				return err
+			}
+			return m.Set("foo", "abcdef", "123456", "456")
+		}, PolicyViolationErr, []string{RuleReuse}},
+		{"set reuse beyond limit", func(m *Manager) error {
+			err := m.Set("foo", "123456", "abcdef", "456")
+			if err != nil {
+				return err
+			}
+			err = m.Set("foo", "abcdef", "ghijkl", "456")
+			if err != nil {
+				return err
+			}
+			return m.Set("foo", "ghijkl", "123456", "456")
+		}, nil, nil},
+		{"rollback ignores policy", func(m *Manager) error {
+			m.SetPasswordPolicy(nil)
+			err := m.Overwrite("foo", "1", "456")
+			if err != nil {
+				return err
+			}
+			m.SetPasswordPolicy(RulePolicy{MinLength: 6})
+			return m.Rollback("foo", 1, "456")
+		}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(NewTemporaryStorage())
+			m.EnableHistory(5)
+
+			err := m.Overwrite("foo", "123456", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.SetPasswordPolicy(RulePolicy{MinLength: 6, RejectReuse: 2})
+
+			// test
+			err = tt.run(m)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			var policyErr *PolicyError
+			if errors.As(err, &policyErr) {
+				got := make([]string, len(policyErr.Violations))
+				for i, v := range policyErr.Violations {
+					got[i] = v.Rule
+				}
+				if !reflect.DeepEqual(got, tt.wantRules) {
+					t.Errorf("violations = %v, want %v", got, tt.wantRules)
+				}
+			}
+
+			// cleanup test
+			Hash = oldHash
+		})
+	}
+}
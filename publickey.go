@@ -0,0 +1,235 @@
+package password
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"strings"
+	"time"
+)
+
+// RecoveryKeyErr is wrapped if a recovery public or private key cannot be parsed.
+var RecoveryKeyErr = errors.New("invalid recovery key")
+
+// recoveryPublicKeyPrefix marks encoded X25519 public keys of GenerateRecoveryKeyPair.
+const recoveryPublicKeyPrefix = "x25519-public:"
+
+// recoveryPrivateKeyPrefix marks encoded X25519 private keys of GenerateRecoveryKeyPair.
+const recoveryPrivateKeyPrefix = "x25519-private:"
+
+// publicRecoveryPrefix marks recovery entries that are encrypted to a public key.
+// The colon is not part of the base64 alphabet, i.e. other ciphertexts never carry this prefix.
+const publicRecoveryPrefix = "x25519:"
+
+// publicRecoveryInfo binds derived keys to this format.
+const publicRecoveryInfo = "github.com/image357/password recovery v1"
+
+// GenerateRecoveryKeyPair returns a new X25519 key pair for EnablePublicKeyRecovery.
+// Services only need the public key. Keep the private key offline, e.g. in a file for cmd/recovery.
+func GenerateRecoveryKeyPair() (string, string, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicText := recoveryPublicKeyPrefix + base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+	privateText := recoveryPrivateKeyPrefix + base64.RawURLEncoding.EncodeToString(privateKey.Bytes())
+	return publicText, privateText, nil
+}
+
+// parseRecoveryPublicKey decodes a public key of GenerateRecoveryKeyPair.
+func parseRecoveryPublicKey(publicKey string) (*ecdh.PublicKey, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(publicKey), recoveryPublicKeyPrefix)
+	if !found {
+		return nil, fmt.Errorf("%w: missing prefix %v", RecoveryKeyErr, recoveryPublicKeyPrefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", RecoveryKeyErr, err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", RecoveryKeyErr, err)
+	}
+	return key, nil
+}
+
+// parseRecoveryPrivateKey decodes a private key of GenerateRecoveryKeyPair.
+func parseRecoveryPrivateKey(privateKey string) (*ecdh.PrivateKey, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(privateKey), recoveryPrivateKeyPrefix)
+	if !found {
+		return nil, fmt.Errorf("%w: missing prefix %v", RecoveryKeyErr, recoveryPrivateKeyPrefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", RecoveryKeyErr, err)
+	}
+	defer clear(data)
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", RecoveryKeyErr, err)
+	}
+	return key, nil
+}
+
+// publicRecoveryKey derives the symmetric key of a public key recovery entry from an X25519 shared secret.
+// Both public keys are bound to the key as salt.
+func publicRecoveryKey(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, publicRecoveryInfo, chacha20poly1305.KeySize)
+}
+
+// sealPublicRecovery encrypts text to a recipient public key with an ephemeral X25519 key (hybrid encryption).
+// The result is "x25519:" + base64(ephemeral public key) + ":" + base64(nonce + XChaCha20-Poly1305 ciphertext).
+func sealPublicRecovery(text string, recipient *ecdh.PublicKey) (string, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	defer clear(shared)
+
+	key, err := publicRecoveryKey(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+
+	sealed, err := sealAEAD(CipherXChaCha20Poly1305, key, []byte(text), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return publicRecoveryPrefix + base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openPublicRecovery decrypts a ciphertext of sealPublicRecovery with the recipient private key.
+func openPublicRecovery(ciphertext string, recipient *ecdh.PrivateKey) (string, error) {
+	rest, found := strings.CutPrefix(ciphertext, publicRecoveryPrefix)
+	if !found {
+		return "", fmt.Errorf("%w: not a public key recovery entry", CorruptEntryErr)
+	}
+	encodedEphemeral, encodedSealed, found := strings.Cut(rest, ":")
+	if !found {
+		return "", fmt.Errorf("%w: missing separator", CorruptEntryErr)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encodedEphemeral)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encodedSealed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+
+	shared, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CorruptEntryErr, err)
+	}
+	defer clear(shared)
+
+	key, err := publicRecoveryKey(shared, ephemeral, recipient.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+
+	text, err := openAEAD(CipherXChaCha20Poly1305, key, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// EnablePublicKeyRecovery will enforce recovery key file storage alongside passwords,
+// where storage keys are encrypted to publicKey of GenerateRecoveryKeyPair.
+// The manager never holds a secret that decrypts recovery entries. Use RecoverStorageKey with the private key instead.
+// Recovery entries that are encrypted to a public key cannot be read or migrated by the manager.
+func (m *Manager) EnablePublicKeyRecovery(publicKey string) error {
+	key, err := parseRecoveryPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	previous := m.swapRecoveryKey(nil, key)
+	m.withRecovery = true
+	previous.Destroy()
+	return nil
+}
+
+// RecoverStorageKey returns the storage key of id from its recovery entry, which must be encrypted to the public key
+// of privateKey (see EnablePublicKeyRecovery).
+func (m *Manager) RecoverStorageKey(id string, privateKey string) (string, error) {
+	id = NormalizeId(id)
+	recoveryId := id
+	if !strings.HasSuffix(id, RecoveryIdSuffix) {
+		recoveryId = id + RecoveryIdSuffix
+	}
+
+	key, err := parseRecoveryPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedData, err := m.storageBackend.Retrieve(recoveryId)
+	if err != nil {
+		return "", err
+	}
+
+	packedData, err := openPublicRecovery(encryptedData, key)
+	if err != nil {
+		return "", err
+	}
+
+	storedId, storageKey, err := unpackData(packedData)
+	if err != nil {
+		return "", err
+	}
+	if storedId != recoveryId {
+		return "", fmt.Errorf("%w: got %v, want %v", IdMismatchErr, storedId, recoveryId)
+	}
+
+	return storageKey, nil
+}
+
+// writeRecovery stores the recovery entry of a normalized recovery id that holds storageKey.
+func (m *Manager) writeRecovery(recoveryId string, storageKey string) error {
+	m.recoveryMutex.RLock()
+	defer m.recoveryMutex.RUnlock()
+
+	publicKey := m.recoveryPublicKey
+	if publicKey == nil {
+		var err error
+		useErr := m.recoveryKey.Use(func(recoveryKey string) {
+			err = m.overwrite(recoveryId, storageKey, recoveryKey, time.Time{})
+		})
+		if useErr != nil {
+			return useErr
+		}
+		return err
+	}
+
+	packedData, err := packData(recoveryId, storageKey)
+	if err != nil {
+		return err
+	}
+	encryptedData, err := sealPublicRecovery(packedData, publicKey)
+	if err != nil {
+		return err
+	}
+	return m.storageBackend.Store(recoveryId, encryptedData)
+}
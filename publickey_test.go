@@ -0,0 +1,123 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestManager_EnablePublicKeyRecovery(t *testing.T) {
+	// init test
+	publicKey, privateKey, err := GenerateRecoveryKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := GenerateRecoveryKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	err = m.EnablePublicKeyRecovery(privateKey)
+	if !errors.Is(err, RecoveryKeyErr) {
+		t.Errorf("EnablePublicKeyRecovery() error = %v, want %v", err, RecoveryKeyErr)
+	}
+	err = m.EnablePublicKeyRecovery(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.recoveryKey.Len() != 0 {
+		t.Errorf("manager holds a recovery secret")
+	}
+
+	err = m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RewriteKey("foo", "456", "789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.storageBackend.Retrieve("foo" + RecoveryIdSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(data, publicRecoveryPrefix) {
+		t.Errorf("recovery entry = %v, want prefix %v", data, publicRecoveryPrefix)
+	}
+
+	// tests
+	tests := []struct {
+		name       string
+		id         string
+		privateKey string
+		want       string
+		wantErr    error
+	}{
+		{"recover", "foo", privateKey, "789", nil},
+		{"recovery id", "foo" + RecoveryIdSuffix, privateKey, "789", nil},
+		{"other key", "foo", otherKey, "", AuthenticationErr},
+		{"public key", "foo", publicKey, "", RecoveryKeyErr},
+		{"missing", "bar", privateKey, "", NotFoundErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.RecoverStorageKey(tt.id, tt.privateKey)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RecoverStorageKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RecoverStorageKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the manager cannot read or migrate recovery entries
+	_, err = m.Get("foo"+RecoveryIdSuffix, "")
+	if err == nil {
+		t.Errorf("Get() of a public key recovery entry succeeded")
+	}
+	err = m.SetCipher(CipherXChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := m.Migrate(func(string) string { return "789" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 1 || migrated[0] != "foo" {
+		t.Errorf("Migrate() = %v, want [foo]", migrated)
+	}
+
+	// rotating the master key of envelope entries rewrites their recovery entries
+	envelope := NewManagerWithStorage(NewTemporaryStorage())
+	envelope.SetHashFunc(sha256Hash)
+	envelope.EnableEnvelopeEncryption()
+	err = envelope.EnablePublicKeyRecovery(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = envelope.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = envelope.RotateMasterKey("456", "789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := envelope.RecoverStorageKey("foo", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "789" {
+		t.Errorf("RecoverStorageKey() after RotateMasterKey() = %v, want 789", got)
+	}
+
+	// symmetric recovery replaces the public key
+	m.EnableRecovery("abc")
+	if m.recoveryPublicKey != nil {
+		t.Errorf("EnableRecovery() kept the public key")
+	}
+	m.DisableRecovery()
+}
@@ -0,0 +1,124 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"github.com/image357/password/log"
+	"strings"
+	"time"
+)
+
+// ReaperIntervalErr is returned by StartReaper if the interval is not positive.
+var ReaperIntervalErr = errors.New("invalid reaper interval")
+
+// Reap purges all expired passwords together with their versions and recovery entries.
+// storageKeyForIds returns the storage key of an id. Passwords that cannot be decrypted are skipped.
+// It returns the purged ids.
+func (m *Manager) Reap(storageKeyForIds func(id string) string) ([]string, error) {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]string, 0)
+	var lastErr error = nil
+	for _, id := range ids {
+		if strings.HasSuffix(id, RecoveryIdSuffix) || isHistoryId(id) || id == KeyringId {
+			continue
+		}
+
+		expired, err := m.reapId(id, storageKeyForIds(id))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if expired {
+			purged = append(purged, id)
+		}
+	}
+
+	return purged, lastErr
+}
+
+// reapId deletes a normalized id and its companions if the password has expired.
+func (m *Manager) reapId(id string, key string) (bool, error) {
+	m.lockId(id)
+	defer m.unlockId(id)
+
+	entry, err := m.getEntry(id, key)
+	if err != nil {
+		log.Debug("reaper cannot read password", "id", id, "error", err)
+		return false, nil
+	}
+	if !entry.Expired() {
+		return false, nil
+	}
+
+	err = m.delete(id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// StartReaper starts a background goroutine that calls Reap every interval until StopReaper is called.
+// storageKeyForIds returns the storage key of an id. A running reaper is replaced.
+// It returns ReaperIntervalErr and keeps a running reaper if interval is not positive.
+func (m *Manager) StartReaper(interval time.Duration, storageKeyForIds func(id string) string) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: %v", ReaperIntervalErr, interval)
+	}
+
+	m.reaperMutex.Lock()
+	defer m.reaperMutex.Unlock()
+
+	m.stopReaper()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	m.reaperStop, m.reaperDone = stop, done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				purged, err := m.Reap(storageKeyForIds)
+				if err != nil {
+					log.Warn("reaper cannot purge expired passwords", "error", err)
+				}
+				if len(purged) != 0 {
+					log.Info("reaper purged expired passwords", "ids", purged)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopReaper stops the background goroutine of StartReaper and waits for it to finish.
+func (m *Manager) StopReaper() {
+	m.reaperMutex.Lock()
+	defer m.reaperMutex.Unlock()
+
+	m.stopReaper()
+}
+
+// stopReaper stops a running reaper. The reaper mutex must be held.
+func (m *Manager) stopReaper() {
+	if m.reaperStop == nil {
+		return
+	}
+
+	close(m.reaperStop)
+	<-m.reaperDone
+	m.reaperStop, m.reaperDone = nil, nil
+}
@@ -0,0 +1,164 @@
+package password
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManager_Expiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(m *Manager) error
+		wantErr error
+	}{
+		{"get expired", func(m *Manager) error {
+			_, err := m.Get("expired", "456")
+			return err
+		}, ExpiredErr},
+		{"check expired", func(m *Manager) error {
+			_, err := m.Check("expired", "123", "456")
+			return err
+		}, ExpiredErr},
+		{"get entry expired", func(m *Manager) error {
+			_, err := m.GetEntry("expired", "456")
+			return err
+		}, ExpiredErr},
+		{"get valid", func(m *Manager) error {
+			_, err := m.Get("valid", "456")
+			return err
+		}, nil},
+		{"set expired without old password", func(m *Manager) error {
+			err := m.Set("expired", "wrong", "789", "456")
+			if err != nil {
+				return err
+			}
+			_, err = m.Get("expired", "456")
+			return err
+		}, nil},
+		{"set valid with wrong password", func(m *Manager) error {
+			return m.SetWithExpiry("valid", "wrong", "789", "456", time.Now().Add(time.Hour))
+		}, IncorrectPasswordErr},
+		{"overwrite clears expiry", func(m *Manager) error {
+			err := m.Overwrite("valid", "789", "456")
+			if err != nil {
+				return err
+			}
+			entry, err := m.GetEntry("valid", "456")
+			if err != nil {
+				return err
+			}
+			if !entry.Expires.IsZero() {
+				return errors.New("expiry not cleared")
+			}
+			return nil
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(NewTemporaryStorage())
+
+			err := m.OverwriteWithExpiry("expired", "123", "456", time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.SetWithExpiry("valid", "", "123", "456", time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// test
+			if err = tt.run(m); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			// cleanup test
+			Hash = oldHash
+		})
+	}
+}
+
+func TestManager_Reap(t *testing.T) {
+	// init
+	oldHash := Hash
+	Hash = sha256Hash
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.EnableHistory(2)
+	m.EnableRecovery("recovery_key")
+
+	err := m.Overwrite("expired", "abc", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OverwriteWithExpiry("expired", "123", "456", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OverwriteWithExpiry("valid", "123", "456", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OverwriteWithExpiry("other/key", "123", "789", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DisableRecovery()
+
+	// test
+	purged, err := m.Reap(func(id string) string { return "456" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(purged, []string{"expired"}) {
+		t.Errorf("Reap() = %v, want [expired]", purged)
+	}
+
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"other/key", "other/key.recovery", "valid", "valid.recovery"}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("List() after Reap() = %v, want %v", list, want)
+	}
+
+	// background reaper
+	for _, interval := range []time.Duration{0, -time.Second} {
+		err = m.StartReaper(interval, func(id string) string { return "789" })
+		if !errors.Is(err, ReaperIntervalErr) {
+			t.Errorf("StartReaper(%v) error = %v, want %v", interval, err, ReaperIntervalErr)
+		}
+	}
+	err = m.StartReaper(10*time.Millisecond, func(id string) string { return "789" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		exists, err := m.Exists("other/key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.StopReaper()
+	m.StopReaper()
+
+	list, err = m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"valid", "valid.recovery"}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("List() after StartReaper() = %v, want %v", list, want)
+	}
+
+	// cleanup
+	Hash = oldHash
+}
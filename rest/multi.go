@@ -57,6 +57,12 @@ type multiCleanData struct {
 	AccessToken string `form:"accessToken" json:"accessToken" xml:"accessToken"  binding:"required"`
 }
 
+type multiGenerateData struct {
+	AccessToken string              `form:"accessToken" json:"accessToken" xml:"accessToken"  binding:"required"`
+	Id          string              `form:"id" json:"id" xml:"id"  binding:"required"`
+	Options     pwd.GenerateOptions `form:"options" json:"options" xml:"options"`
+}
+
 // StartMultiService creates a multi password REST service.
 // The service binds to
 // "/prefix/overwrite" (PUT),
@@ -67,7 +73,8 @@ type multiCleanData struct {
 // "/prefix/exists" (GET),
 // "/prefix/list" (GET),
 // "/prefix/delete" (DELETE),
-// "/prefix/clean" (DELETE).
+// "/prefix/clean" (DELETE),
+// "/prefix/generate" (PUT).
 // The callback of type TestAccessFunc will be called for every request to determine access.
 func StartMultiService(bindAddress string, prefix string, key string, callback TestAccessFunc) error {
 	// prepare arguments
@@ -90,6 +97,7 @@ func StartMultiService(bindAddress string, prefix string, key string, callback T
 	localListCallback := func(c *gin.Context) { multiListCallback(c, manager, service) }
 	localDeleteCallback := func(c *gin.Context) { multiDeleteCallback(c, manager, service) }
 	localCleanCallback := func(c *gin.Context) { multiCleanCallback(c, manager, service) }
+	localGenerateCallback := func(c *gin.Context) { multiGenerateCallback(c, manager, service) }
 
 	// setup REST endpoints
 	engine.PUT(pathlib.Join("/", prefix, "/overwrite"), localOverwriteCallback)
@@ -101,6 +109,7 @@ func StartMultiService(bindAddress string, prefix string, key string, callback T
 	engine.GET(pathlib.Join("/", prefix, "/list"), localListCallback)
 	engine.DELETE(pathlib.Join("/", prefix, "/delete"), localDeleteCallback)
 	engine.DELETE(pathlib.Join("/", prefix, "/clean"), localCleanCallback)
+	engine.PUT(pathlib.Join("/", prefix, "/generate"), localGenerateCallback)
 
 	go func() {
 		log.Info(
@@ -154,10 +163,10 @@ func multiOverwriteCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Overwrite(data.Id, data.Password, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Overwrite(data.Id, data.Password, key) })
 	if err != nil {
 		log.Error("rest: Overwrite failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -184,10 +193,14 @@ func multiGetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	password, err := m.Get(data.Id, getStorageKey(s))
+	var password string
+	err = useStorageKey(s, func(key string) (err error) {
+		password, err = m.Get(data.Id, key)
+		return err
+	})
 	if err != nil {
 		log.Error("rest: Get failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -214,10 +227,14 @@ func multiCheckCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	result, err := m.Check(data.Id, data.Password, getStorageKey(s))
+	var result bool
+	err = useStorageKey(s, func(key string) (err error) {
+		result, err = m.Check(data.Id, data.Password, key)
+		return err
+	})
 	if err != nil {
 		log.Error("rest: Check failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -244,10 +261,10 @@ func multiSetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Set(data.Id, data.OldPassword, data.NewPassword, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Set(data.Id, data.OldPassword, data.NewPassword, key) })
 	if err != nil {
 		log.Error("rest: Set failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -274,10 +291,10 @@ func multiUnsetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Unset(data.Id, data.Password, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Unset(data.Id, data.Password, key) })
 	if err != nil {
 		log.Error("rest: Unset failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -307,7 +324,7 @@ func multiExistsCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 	result, err := m.Exists(data.Id)
 	if err != nil {
 		log.Error("rest: Exists failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -334,12 +351,15 @@ func multiListCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	list, err := m.List()
+	list, orphaned, err := m.ListPasswords()
 	if err != nil {
-		log.Error("rest: List failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		log.Error("rest: ListPasswords failed", "error", err)
+		c.JSON(errorResponse(err))
 		return
 	}
+	if len(orphaned) > 0 {
+		log.Warn("rest: orphaned recovery entries", "ids", orphaned)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"ids": list})
 }
@@ -367,7 +387,7 @@ func multiDeleteCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 	err = m.Delete(data.Id)
 	if err != nil {
 		log.Error("rest: Delete failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -397,9 +417,43 @@ func multiCleanCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 	err = m.Clean()
 	if err != nil {
 		log.Error("rest: Clean failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func multiGenerateCallback(c *gin.Context, m *pwd.Manager, s *restService) {
+	logContext(c)
+
+	var data multiGenerateData
+	err := c.ShouldBindJSON(&data)
+	if err != nil {
+		log.Warn(processDataLogMsg, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{})
+		return
+	}
+
+	ip := c.ClientIP()
+	url := c.Request.URL.String()
+	id := pwd.NormalizeId(data.Id)
+	if !s.hasAccess(data.AccessToken, ip, url, id) {
+		log.Warn(accessDeniedLogMsg, "ip", ip, "resource", url, "id", id, "token", data.AccessToken)
+		c.JSON(http.StatusForbidden, gin.H{})
+		return
+	}
+
+	var password string
+	err = useStorageKey(s, func(key string) (err error) {
+		password, err = m.Generate(data.Id, data.Options, key)
+		return err
+	})
+	if err != nil {
+		log.Error("rest: Generate failed", "error", err)
+		c.JSON(errorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"password": password})
+}
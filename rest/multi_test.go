@@ -113,6 +113,10 @@ func TestMultiRestCalls(t *testing.T) {
 			"Overwrite missing data", http.MethodPut, "http://localhost:8080/prefix/overwrite", true,
 			`{"id": "someId", "password": "789"}`, `{}`, http.StatusBadRequest,
 		},
+		{
+			"Overwrite policy violation", http.MethodPut, "http://localhost:8080/prefix/overwrite", true,
+			`{"accessToken": "abc", "id": "someId", "password": "my-someid"}`, `{"error":"password policy violation","violations":[{"rule":"contains_id","message":"password must not contain its id"}]}`, http.StatusUnprocessableEntity,
+		},
 
 		// Get
 		{
@@ -121,7 +125,7 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 		{
 			"Get invalid id", http.MethodGet, "http://localhost:8080/prefix/get", true,
-			`{"accessToken": "abc", "id": "anotherId"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "anotherId"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Get access denied", http.MethodGet, "http://localhost:8080/prefix/get", false,
@@ -147,7 +151,7 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 		{
 			"Check invalid id", http.MethodGet, "http://localhost:8080/prefix/check", true,
-			`{"accessToken": "abc", "id": "anotherId", "password": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "anotherId", "password": "456"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Check access denied", http.MethodGet, "http://localhost:8080/prefix/check", false,
@@ -163,13 +167,17 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 
 		// Set
+		{
+			"Set policy violation", http.MethodPut, "http://localhost:8080/prefix/set", true,
+			`{"accessToken": "abc", "id": "someId", "oldPassword": "123", "newPassword": "SomeId!"}`, `{"error":"password policy violation","violations":[{"rule":"contains_id","message":"password must not contain its id"}]}`, http.StatusUnprocessableEntity,
+		},
 		{
 			"Set success", http.MethodPut, "http://localhost:8080/prefix/set", true,
 			`{"accessToken": "abc", "id": "someId", "oldPassword": "123", "newPassword": "456"}`, `{}`, http.StatusOK,
 		},
 		{
 			"Set invalid password", http.MethodPut, "http://localhost:8080/prefix/set", true,
-			`{"accessToken": "abc", "id": "someId", "oldPassword": "123", "newPassword": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "someId", "oldPassword": "123", "newPassword": "456"}`, `{"error":"password is incorrect"}`, http.StatusConflict,
 		},
 		{
 			"Set access denied", http.MethodPut, "http://localhost:8080/prefix/set", false,
@@ -191,7 +199,7 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 		{
 			"Unset invalid id", http.MethodDelete, "http://localhost:8080/prefix/unset", true,
-			`{"accessToken": "abc", "id": "someId", "password": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "someId", "password": "456"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Overwrite create", http.MethodPut, "http://localhost:8080/prefix/overwrite", true,
@@ -199,7 +207,7 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 		{
 			"Unset invalid password", http.MethodDelete, "http://localhost:8080/prefix/unset", true,
-			`{"accessToken": "abc", "id": "someId", "password": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "someId", "password": "456"}`, `{"error":"password is incorrect"}`, http.StatusConflict,
 		},
 		{
 			"Unset access denied", http.MethodDelete, "http://localhost:8080/prefix/unset", false,
@@ -273,7 +281,7 @@ func TestMultiRestCalls(t *testing.T) {
 		},
 		{
 			"Delete invalid id", http.MethodDelete, "http://localhost:8080/prefix/delete", true,
-			`{"accessToken": "abc", "id": "someId"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "id": "someId"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Delete access denied", http.MethodDelete, "http://localhost:8080/prefix/delete", false,
@@ -313,6 +321,36 @@ func TestMultiRestCalls(t *testing.T) {
 			"Clean missing data", http.MethodDelete, "http://localhost:8080/prefix/clean", true,
 			`{}`, `{}`, http.StatusBadRequest,
 		},
+
+		// Generate
+		{
+			"Generate success", http.MethodPut, "http://localhost:8080/prefix/generate", true,
+			`{"accessToken": "abc", "id": "generated", "options": {"length": 4, "charset": "x"}}`, `{"password":"xxxx"}`, http.StatusOK,
+		},
+		{
+			"Generate too long", http.MethodPut, "http://localhost:8080/prefix/generate", true,
+			`{"accessToken": "abc", "id": "generated", "options": {"length": 2000000000}}`, `{"error":"invalid generate options"}`, http.StatusBadRequest,
+		},
+		{
+			"Get generated", http.MethodGet, "http://localhost:8080/prefix/get", true,
+			`{"accessToken": "abc", "id": "generated"}`, `{"password":"xxxx"}`, http.StatusOK,
+		},
+		{
+			"Generate invalid options", http.MethodPut, "http://localhost:8080/prefix/generate", true,
+			`{"accessToken": "abc", "id": "generated", "options": {"length": 1, "digits": true, "symbols": true, "requireEachClass": true}}`, `{"error":"invalid generate options"}`, http.StatusBadRequest,
+		},
+		{
+			"Generate access denied", http.MethodPut, "http://localhost:8080/prefix/generate", false,
+			`{"accessToken": "abc", "id": "generated"}`, `{}`, http.StatusForbidden,
+		},
+		{
+			"Generate bad data", http.MethodPut, "http://localhost:8080/prefix/generate", true,
+			`{"accessToken": abc, "id": "generated"}`, `{}`, http.StatusBadRequest,
+		},
+		{
+			"Generate missing data", http.MethodPut, "http://localhost:8080/prefix/generate", true,
+			`{"accessToken": "abc"}`, `{}`, http.StatusBadRequest,
+		},
 	}
 	// init
 	oldLevel := log.Level(log.LevelDebug)
@@ -320,6 +358,9 @@ func TestMultiRestCalls(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	// recovery entries are hidden from /list and deleted together with their password
+	password.EnableRecovery("rec")
+	password.SetPasswordPolicy(password.RulePolicy{ForbidId: true})
 	err = StartMultiService(":8080", "/prefix", "123", DebugAccessCallback)
 	if err != nil {
 		t.Fatal(err)
@@ -385,5 +426,7 @@ func TestMultiRestCalls(t *testing.T) {
 		t.Error(err)
 	}
 
+	password.DisableRecovery()
+	password.SetPasswordPolicy(nil)
 	log.Level(oldLevel)
 }
@@ -39,11 +39,10 @@ type TestAccessFunc func(token string, ip string, resource string, id string) bo
 
 // restService contains all necessary information for external handling of a REST service.
 type restService struct {
-	name             string
-	server           *http.Server
-	storageKeyBytes  []byte
-	storageKeySecret []byte
-	hasAccess        TestAccessFunc
+	name       string
+	server     *http.Server
+	storageKey *pwd.SecureBuffer
+	hasAccess  TestAccessFunc
 }
 
 // services contains the global map of all started REST servers.
@@ -82,9 +81,46 @@ type simpleDeleteData struct {
 	AccessToken string `form:"accessToken" json:"accessToken" xml:"accessToken"  binding:"required"`
 }
 
-// getStorageKey decrypts the storage key that was set by StartSimpleService or StartMultiService.
-func getStorageKey(service *restService) string {
-	return pwd.DecryptOTP(service.storageKeyBytes, service.storageKeySecret)
+// useStorageKey calls fn with the storage key that was set by StartSimpleService or StartMultiService.
+// The key refers to secure memory and must not be retained after fn returns (see password.SecureBuffer.Use).
+// It returns the error of fn or password.DestroyedBufferErr if the service was stopped, in which case fn is not called.
+func useStorageKey(service *restService, fn func(key string) error) error {
+	var err error
+	useErr := service.storageKey.Use(func(key string) { err = fn(key) })
+	if useErr != nil {
+		return useErr
+	}
+	return err
+}
+
+// errorResponse maps errors of the password package to an HTTP status code and a JSON body.
+// Password policy violations additionally list all violated rules.
+// Unknown errors are reported as internal server errors without any details.
+func errorResponse(err error) (int, gin.H) {
+	var policyErr *pwd.PolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		return http.StatusUnprocessableEntity, gin.H{"error": pwd.PolicyViolationErr.Error(), "violations": policyErr.Violations}
+	case errors.Is(err, pwd.NotFoundErr):
+		return http.StatusNotFound, gin.H{"error": pwd.NotFoundErr.Error()}
+	case errors.Is(err, pwd.AuthenticationErr):
+		return http.StatusUnauthorized, gin.H{"error": pwd.AuthenticationErr.Error()}
+	case errors.Is(err, pwd.ExpiredErr):
+		return http.StatusGone, gin.H{"error": pwd.ExpiredErr.Error()}
+	case errors.Is(err, pwd.IncorrectPasswordErr):
+		return http.StatusConflict, gin.H{"error": pwd.IncorrectPasswordErr.Error()}
+	case errors.Is(err, pwd.IdMismatchErr):
+		return http.StatusUnprocessableEntity, gin.H{"error": pwd.IdMismatchErr.Error()}
+	case errors.Is(err, pwd.CorruptEntryErr):
+		return http.StatusUnprocessableEntity, gin.H{"error": pwd.CorruptEntryErr.Error()}
+	case errors.Is(err, pwd.ReservedIdErr):
+		return http.StatusUnprocessableEntity, gin.H{"error": pwd.ReservedIdErr.Error()}
+	case errors.Is(err, pwd.GenerateOptionsErr):
+		return http.StatusBadRequest, gin.H{"error": pwd.GenerateOptionsErr.Error()}
+	case errors.Is(err, pwd.DestroyedBufferErr):
+		return http.StatusServiceUnavailable, gin.H{}
+	}
+	return http.StatusInternalServerError, gin.H{}
 }
 
 // preparePrefix returns a normalized prefix.
@@ -122,7 +158,9 @@ func setupService(bindAddress string, prefix string, key string, callback TestAc
 		Addr:    bindAddress,
 		Handler: e,
 	}
-	s.storageKeyBytes, s.storageKeySecret = pwd.EncryptOTP(key)
+	keyBytes := []byte(key)
+	s.storageKey = pwd.NewSecureBuffer(keyBytes)
+	clear(keyBytes)
 	s.hasAccess = callback
 
 	services[name] = s
@@ -210,7 +248,7 @@ func StartSimpleService(bindAddress string, prefix string, key string, callback
 }
 
 // StopService will block execution and try to gracefully shut down any REST service during the timeout period.
-// The service is guaranteed to be closed at the end of the timeout.
+// The service is guaranteed to be closed at the end of the timeout. The storage key of the service is wiped from memory.
 func StopService(timeout int, bindAddress string, prefix string) error {
 	// prepare arguments
 	prefix = preparePrefix(prefix)
@@ -241,7 +279,8 @@ func StopService(timeout int, bindAddress string, prefix string) error {
 
 	// cleanup service
 	service.server = nil
-	service.storageKeyBytes, service.storageKeySecret = nil, nil
+	// handlers may still run, i.e. keep the destroyed buffer, which rejects further use
+	service.storageKey.Destroy()
 	service.hasAccess = nil
 	delete(services, name)
 
@@ -269,10 +308,10 @@ func simpleOverwriteCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Overwrite(defaultId, data.Password, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Overwrite(defaultId, data.Password, key) })
 	if err != nil {
 		log.Error("rest: Overwrite failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -299,10 +338,14 @@ func simpleGetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	password, err := m.Get(defaultId, getStorageKey(s))
+	var password string
+	err = useStorageKey(s, func(key string) (err error) {
+		password, err = m.Get(defaultId, key)
+		return err
+	})
 	if err != nil {
 		log.Error("rest: Get failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -329,10 +372,14 @@ func simpleCheckCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	result, err := m.Check(defaultId, data.Password, getStorageKey(s))
+	var result bool
+	err = useStorageKey(s, func(key string) (err error) {
+		result, err = m.Check(defaultId, data.Password, key)
+		return err
+	})
 	if err != nil {
 		log.Error("rest: Check failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -359,10 +406,10 @@ func simpleSetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Set(defaultId, data.OldPassword, data.NewPassword, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Set(defaultId, data.OldPassword, data.NewPassword, key) })
 	if err != nil {
 		log.Error("rest: Set failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -389,10 +436,10 @@ func simpleUnsetCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 		return
 	}
 
-	err = m.Unset(defaultId, data.Password, getStorageKey(s))
+	err = useStorageKey(s, func(key string) error { return m.Unset(defaultId, data.Password, key) })
 	if err != nil {
 		log.Error("rest: Unset failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -422,7 +469,7 @@ func simpleExistsCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 	result, err := m.Exists(defaultId)
 	if err != nil {
 		log.Error("rest: Exists failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -452,7 +499,7 @@ func simpleDeleteCallback(c *gin.Context, m *pwd.Manager, s *restService) {
 	err = m.Delete(defaultId)
 	if err != nil {
 		log.Error("rest: Delete failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{})
+		c.JSON(errorResponse(err))
 		return
 	}
 
@@ -2,11 +2,14 @@ package rest
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/image357/password"
 	"github.com/image357/password/log"
 	"io"
 	"net/http"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -189,7 +192,7 @@ func TestSimpleRestCalls(t *testing.T) {
 		},
 		{
 			"Set invalid password", http.MethodPut, "http://localhost:8080/prefix/set", true,
-			`{"accessToken": "abc", "oldPassword": "123", "newPassword": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "oldPassword": "123", "newPassword": "456"}`, `{"error":"password is incorrect"}`, http.StatusConflict,
 		},
 		{
 			"Set access denied", http.MethodPut, "http://localhost:8080/prefix/set", false,
@@ -211,7 +214,7 @@ func TestSimpleRestCalls(t *testing.T) {
 		},
 		{
 			"Unset failure", http.MethodDelete, "http://localhost:8080/prefix/unset", true,
-			`{"accessToken": "abc", "password": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "password": "456"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Overwrite create", http.MethodPut, "http://localhost:8080/prefix/overwrite", true,
@@ -219,7 +222,7 @@ func TestSimpleRestCalls(t *testing.T) {
 		},
 		{
 			"Unset invalid password", http.MethodDelete, "http://localhost:8080/prefix/unset", true,
-			`{"accessToken": "abc", "password": "456"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc", "password": "456"}`, `{"error":"password is incorrect"}`, http.StatusConflict,
 		},
 		{
 			"Unset access denied", http.MethodDelete, "http://localhost:8080/prefix/unset", false,
@@ -259,7 +262,7 @@ func TestSimpleRestCalls(t *testing.T) {
 		},
 		{
 			"Delete failure", http.MethodDelete, "http://localhost:8080/prefix/delete", true,
-			`{"accessToken": "abc"}`, `{}`, http.StatusInternalServerError,
+			`{"accessToken": "abc"}`, `{"error":"id not found"}`, http.StatusNotFound,
 		},
 		{
 			"Delete access denied", http.MethodDelete, "http://localhost:8080/prefix/delete", false,
@@ -347,3 +350,151 @@ func TestSimpleRestCalls(t *testing.T) {
 
 	log.Level(oldLevel)
 }
+
+func BenchmarkSimpleGet(b *testing.B) {
+	// init
+	oldLevel := log.Level(log.LevelError)
+	err := password.SetStorePath("tests/workdir/BenchmarkSimpleGet")
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = password.Overwrite("default", "456", "123")
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = StartSimpleService(":8081", "/bench", "123", FullAccessCallback)
+	if err != nil {
+		b.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	// benchmarks
+	benchmarks := []struct {
+		name  string
+		cache bool
+	}{
+		{"without key cache", false},
+		{"with key cache", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			password.DisableKeyCache()
+			if bm.cache {
+				err := password.EnableKeyCache(128, time.Minute)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				client := &http.Client{}
+				for pb.Next() {
+					req, err := http.NewRequest(http.MethodGet, "http://localhost:8081/bench/get", strings.NewReader(`{"accessToken": "abc"}`))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					req.Header.Set("Content-Type", "application/json")
+
+					resp, err := client.Do(req)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					_, _ = io.Copy(io.Discard, resp.Body)
+					_ = resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						b.Errorf("StatusCode error = %v, want %v", resp.StatusCode, http.StatusOK)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+
+	// cleanup
+	password.DisableKeyCache()
+	path, err := password.GetStorePath()
+	if err != nil {
+		b.Error(err)
+	}
+	err = os.RemoveAll(path)
+	if err != nil {
+		b.Error(err)
+	}
+
+	err = StopService(1000, ":8081", "/bench")
+	if err != nil {
+		b.Error(err)
+	}
+
+	log.Level(oldLevel)
+}
+
+func Test_errorResponse(t *testing.T) {
+	type args struct {
+		err error
+	}
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		{"not found", args{fmt.Errorf("%w: some id", password.NotFoundErr)}, http.StatusNotFound},
+		{"authentication", args{fmt.Errorf("%w: some reason", password.AuthenticationErr)}, http.StatusUnauthorized},
+		{"expired", args{fmt.Errorf("%w: some id", password.ExpiredErr)}, http.StatusGone},
+		{"incorrect password", args{password.IncorrectPasswordErr}, http.StatusConflict},
+		{"id mismatch", args{fmt.Errorf("%w: some id", password.IdMismatchErr)}, http.StatusUnprocessableEntity},
+		{"policy violation", args{&password.PolicyError{}}, http.StatusUnprocessableEntity},
+		{"corrupt entry", args{fmt.Errorf("%w: some reason", password.CorruptEntryErr)}, http.StatusUnprocessableEntity},
+		{"reserved id", args{fmt.Errorf("%w: some id", password.ReservedIdErr)}, http.StatusUnprocessableEntity},
+		{"generate options", args{fmt.Errorf("%w: some reason", password.GenerateOptionsErr)}, http.StatusBadRequest},
+		{"stopped service", args{password.DestroyedBufferErr}, http.StatusServiceUnavailable},
+		{"unknown", args{errors.New("unknown")}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := errorResponse(tt.args.err); got != tt.want {
+				t.Errorf("errorResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_errorResponse_violations(t *testing.T) {
+	violations := []password.PolicyViolation{{Rule: password.RuleMinLength, Message: "too short"}}
+	status, body := errorResponse(fmt.Errorf("wrapped: %w", &password.PolicyError{Violations: violations}))
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("errorResponse() status = %v, want %v", status, http.StatusUnprocessableEntity)
+	}
+	if body["error"] != password.PolicyViolationErr.Error() {
+		t.Errorf("errorResponse() error = %v, want %v", body["error"], password.PolicyViolationErr.Error())
+	}
+	if !reflect.DeepEqual(body["violations"], violations) {
+		t.Errorf("errorResponse() violations = %v, want %v", body["violations"], violations)
+	}
+}
+
+func Test_useStorageKey(t *testing.T) {
+	s := &restService{storageKey: password.NewSecureBuffer([]byte("456"))}
+	err := useStorageKey(s, func(key string) error {
+		if key != "456" {
+			t.Errorf("useStorageKey() key = %v, want 456", key)
+		}
+		return password.NotFoundErr
+	})
+	if !errors.Is(err, password.NotFoundErr) {
+		t.Errorf("useStorageKey() error = %v, want %v", err, password.NotFoundErr)
+	}
+
+	// handlers that run after StopService never see an empty storage key
+	s.storageKey.Destroy()
+	err = useStorageKey(s, func(key string) error {
+		t.Errorf("useStorageKey() of a stopped service called fn with %v", key)
+		return nil
+	})
+	if !errors.Is(err, password.DestroyedBufferErr) {
+		t.Errorf("useStorageKey() error = %v, want %v", err, password.DestroyedBufferErr)
+	}
+}
@@ -0,0 +1,190 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"github.com/image357/password/log"
+	"slices"
+	"strings"
+)
+
+// RewriteErr is wrapped by RewriteAllKeys if an entry cannot be rewritten.
+var RewriteErr = errors.New("cannot rewrite storage key")
+
+// RewriteProgressFunc is called by RewriteAllKeys after every verified and every written entry.
+// done is the number of processed steps and total the number of all steps.
+type RewriteProgressFunc func(done int, total int)
+
+// rewriteRecord remembers the previous ciphertext of an entry for rollback.
+type rewriteRecord struct {
+	id      string
+	oldData string
+	newData string
+	existed bool
+}
+
+// RewriteAllKeys changes the storage key of all passwords, their versions and the keyring from oldKey to newKey.
+// Every entry is re-encrypted and verified with newKey before anything is written.
+// If any entry cannot be rewritten, all written entries are restored to their old ciphertexts.
+// Versions that were stored with a different key are left unchanged, like in RewriteKey.
+// If enabled, recovery entries will be recreated.
+// progress may be nil. Passwords that are created while RewriteAllKeys runs are not rewritten.
+func (m *Manager) RewriteAllKeys(oldKey string, newKey string, progress RewriteProgressFunc) error {
+	ids, err := m.storageBackend.List()
+	if err != nil {
+		return err
+	}
+
+	// lock all passwords in a fixed order; versions are protected by their password
+	entryIds := make([]string, 0, len(ids))
+	passwordIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if strings.HasSuffix(id, RecoveryIdSuffix) {
+			continue
+		}
+		entryIds = append(entryIds, id)
+		if !isHistoryId(id) {
+			passwordIds = append(passwordIds, id)
+		}
+	}
+	slices.Sort(passwordIds)
+	for _, id := range passwordIds {
+		m.lockId(id)
+		defer m.unlockId(id)
+	}
+
+	// every entry is verified and written, and every password except the keyring gets a recovery entry
+	done, total := 0, 2*len(entryIds)
+	if m.withRecovery {
+		total += len(passwordIds)
+		if slices.Contains(passwordIds, KeyringId) {
+			total--
+		}
+	}
+	report := func(steps int) {
+		done += steps
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	// re-encrypt and verify all entries
+	records := make([]rewriteRecord, 0, len(entryIds))
+	for _, id := range entryIds {
+		oldData, err := m.storageBackend.Retrieve(id)
+		if err != nil {
+			return fmt.Errorf("%w: %v: %w", RewriteErr, id, err)
+		}
+
+		newData, err := m.rewriteData(id, oldData, oldKey, newKey)
+		if err != nil && isHistoryId(id) {
+			log.Warn("cannot rewrite key of version", "id", id)
+			report(2)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v: %w", RewriteErr, id, err)
+		}
+		records = append(records, rewriteRecord{id, oldData, newData, true})
+		report(1)
+	}
+
+	// remember recovery entries
+	if m.withRecovery {
+		for _, id := range passwordIds {
+			if id == KeyringId {
+				continue
+			}
+			recoveryId := id + RecoveryIdSuffix
+			oldData, err := m.storageBackend.Retrieve(recoveryId)
+			if err != nil && !errors.Is(err, NotFoundErr) {
+				return err
+			}
+			records = append(records, rewriteRecord{recoveryId, oldData, "", err == nil})
+		}
+	}
+
+	// commit
+	for i, r := range records {
+		if strings.HasSuffix(r.id, RecoveryIdSuffix) {
+			err = m.writeRecovery(r.id, newKey)
+		} else if r.newData != r.oldData {
+			err = m.storageBackend.Store(r.id, r.newData)
+		}
+		if err != nil {
+			m.rollbackRewrite(records[:i+1])
+			return fmt.Errorf("%w: %v: %w", RewriteErr, r.id, err)
+		}
+		report(1)
+	}
+
+	m.keyringMutex.Lock()
+	defer m.keyringMutex.Unlock()
+	m.resetKeyringCache()
+
+	return nil
+}
+
+// rewriteData returns the ciphertext of encryptedData of a normalized id with newKey and verifies that it decrypts.
+// Envelope ciphertexts are only verified and returned unchanged, since their data keys are wrapped by the keyring.
+// Ciphertexts with key slots only change the slot of oldKey.
+func (m *Manager) rewriteData(id string, encryptedData string, oldKey string, newKey string) (string, error) {
+	if isSlotted(encryptedData) {
+		newData, err := m.rewrapSlot(encryptedData, oldKey, newKey)
+		if err != nil {
+			return "", err
+		}
+		_, err = m.decryptSlots(newData, newKey)
+		if err != nil {
+			return "", err
+		}
+		return newData, nil
+	}
+	if isEnvelope(encryptedData) {
+		_, err := m.decrypt(id, encryptedData, oldKey)
+		if err != nil {
+			return "", err
+		}
+		return encryptedData, nil
+	}
+
+	packedData, err := m.decryptWithKey(encryptedData, oldKey)
+	if err != nil {
+		return "", err
+	}
+
+	newData, err := m.encryptWithKey(packedData, newKey)
+	if err != nil {
+		return "", err
+	}
+
+	verifiedData, err := m.decryptWithKey(newData, newKey)
+	if err != nil {
+		return "", err
+	}
+	if verifiedData != packedData {
+		return "", fmt.Errorf("%w: verification failed", CorruptEntryErr)
+	}
+
+	return newData, nil
+}
+
+// rollbackRewrite restores the old ciphertexts of records in reverse order.
+func (m *Manager) rollbackRewrite(records []rewriteRecord) {
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+
+		var err error
+		if r.existed {
+			err = m.storageBackend.Store(r.id, r.oldData)
+		} else {
+			err = m.storageBackend.Delete(r.id)
+			if errors.Is(err, NotFoundErr) {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Error("cannot roll back storage key", "id", r.id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// failingStorage fails Store of a single id.
+type failingStorage struct {
+	Storage
+	failId string
+}
+
+func (s *failingStorage) Store(id string, data string) error {
+	if id == s.failId {
+		return errors.New("store failed")
+	}
+	return s.Storage.Store(id, data)
+}
+
+func TestManager_RewriteAllKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Storage
+	}{
+		{"FileStorage", NewFileStorage()},
+		{"TemporaryStorage", NewTemporaryStorage()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// init test
+			oldHash := Hash
+			Hash = sha256Hash
+			m := NewManagerWithStorage(tt.backend)
+			switch tt.backend.(type) {
+			case *FileStorage:
+				tt.backend.(*FileStorage).SetStorePath("./tests/workdir/Manager_RewriteAllKeys")
+			}
+
+			m.EnableRecovery("rec")
+			m.EnableHistory(2)
+			for _, id := range []string{"foo", "bar", "baz/qux"} {
+				for _, p := range []string{"1", "2"} {
+					err := m.Overwrite(id, id+p, "456")
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			m.EnableEnvelopeEncryption()
+			err := m.Overwrite("env", "envelope", "456")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// rewrite all entries
+			calls, lastDone, lastTotal := 0, 0, 0
+			err = m.RewriteAllKeys("456", "789", func(done int, total int) {
+				calls++
+				lastDone, lastTotal = done, total
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 8 entries (including versions and the keyring) are verified and written, 4 recovery entries are written
+			if calls != 20 || lastDone != 20 || lastTotal != 20 {
+				t.Errorf("progress calls = %v, done = %v, total = %v, want 20", calls, lastDone, lastTotal)
+			}
+
+			for _, id := range []string{"foo", "bar", "baz/qux", "env"} {
+				_, err = m.Get(id, "789")
+				if err != nil {
+					t.Errorf("Get(%v) error = %v", id, err)
+				}
+				_, err = m.Get(id, "456")
+				if !errors.Is(err, AuthenticationErr) {
+					t.Errorf("Get(%v) error = %v, want %v", id, err, AuthenticationErr)
+				}
+				storedKey, err := m.Get(id+RecoveryIdSuffix, "rec")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if storedKey != "789" {
+					t.Errorf("Get(%v) recovery key = %v, want 789", id, storedKey)
+				}
+			}
+			entry, err := m.GetVersion("foo", 1, "789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Password != "foo1" {
+				t.Errorf("GetVersion() = %v, want foo1", entry.Password)
+			}
+
+			// entries with a different key abort before writing
+			m.DisableEnvelopeEncryption()
+			err = m.Overwrite("other", "123", "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := m.DumpJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.RewriteAllKeys("789", "456", nil)
+			if !errors.Is(err, RewriteErr) || !errors.Is(err, AuthenticationErr) {
+				t.Errorf("RewriteAllKeys() error = %v, want %v", err, RewriteErr)
+			}
+			after, err := m.DumpJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before != after {
+				t.Errorf("RewriteAllKeys() changed storage after failed verification")
+			}
+			err = m.Delete("other")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// storage failures roll back
+			before, err = m.DumpJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.SetStorage(&failingStorage{tt.backend, "foo" + RecoveryIdSuffix})
+			err = m.RewriteAllKeys("789", "456", nil)
+			if !errors.Is(err, RewriteErr) {
+				t.Errorf("RewriteAllKeys() error = %v, want %v", err, RewriteErr)
+			}
+			m.SetStorage(tt.backend)
+			after, err = m.DumpJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before != after {
+				t.Errorf("RewriteAllKeys() did not roll back storage")
+			}
+
+			// versions with a different key are skipped
+			for _, k := range []string{"abc", "789"} {
+				err = m.Overwrite("foo", k, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = m.RewriteAllKeys("789", "456", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions, err := m.ListVersions("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, err = m.GetVersion("foo", versions[len(versions)-1], "abc")
+			if err != nil || entry.Password != "abc" {
+				t.Errorf("GetVersion() = %v, %v, want abc", entry.Password, err)
+			}
+			_, err = m.Get("foo", "456")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+
+			// cleanup test
+			Hash = oldHash
+			err = m.Clean()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.backend.(type) {
+			case *FileStorage:
+				path := tt.backend.(*FileStorage).GetStorePath()
+				err = os.RemoveAll(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
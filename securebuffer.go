@@ -0,0 +1,253 @@
+package password
+
+import (
+	"errors"
+	"github.com/image357/password/log"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// DestroyedBufferErr is returned by SecureBuffer.Use if the buffer is nil or was destroyed.
+var DestroyedBufferErr = errors.New("secure buffer destroyed")
+
+// SecureBuffer holds a long-lived secret outside the Go heap.
+// On supported platforms, the memory is locked into RAM (i.e. never swapped), surrounded by inaccessible guard pages
+// and read-only while the secret is stored. Destroy wipes and releases the memory.
+// A nil or destroyed SecureBuffer holds the empty secret, but Use fails. A SecureBuffer is safe for concurrent use.
+// Prefer Use over String, which copies the secret to the Go heap.
+type SecureBuffer struct {
+	// mutex controls thread-safe access to memory.
+	mutex sync.RWMutex
+
+	// memory holds the secret. It is nil after Destroy.
+	memory *secureMemory
+
+	// views counts running Use calls, which access memory without holding mutex.
+	// Destroyed memory is released by the last view.
+	views int
+}
+
+// secureMemory is a platform-specific allocation of a secret.
+type secureMemory struct {
+	// region is the whole allocation including guard pages.
+	region []byte
+
+	// inner is the accessible part of region without guard pages.
+	inner []byte
+
+	// data is the secret at the end of inner, such that overflows hit the trailing guard page.
+	data []byte
+
+	// locked signals that inner is locked into RAM.
+	locked bool
+
+	// heap signals that the memory is ordinary Go memory, because secure memory is not available.
+	heap bool
+
+	// freed signals that the memory was wiped and released.
+	freed bool
+}
+
+// NewSecureBuffer copies secret into secure memory. The caller should wipe its own copy of secret afterward.
+// If secure memory cannot be allocated or locked (e.g. due to RLIMIT_MEMLOCK), a warning is logged
+// and the buffer falls back to weaker protection, which is reported by Locked.
+func NewSecureBuffer(secret []byte) *SecureBuffer {
+	memory, err := allocSecureMemory(len(secret))
+	if err != nil {
+		log.Warn("cannot allocate secure memory", "error", err)
+		memory = newHeapMemory(len(secret))
+	}
+	if !memory.heap && !memory.locked {
+		log.Warn("cannot lock secure memory")
+	}
+
+	copy(memory.data, secret)
+	err = memory.protect(true)
+	if err != nil {
+		log.Warn("cannot protect secure memory", "error", err)
+	}
+
+	b := &SecureBuffer{memory: memory}
+	runtime.AddCleanup(b, func(memory *secureMemory) { memory.free() }, memory)
+	return b
+}
+
+// newHeapMemory returns ordinary Go memory for a secret of size bytes.
+func newHeapMemory(size int) *secureMemory {
+	data := make([]byte, size)
+	return &secureMemory{region: data, inner: data, data: data, heap: true}
+}
+
+// String returns a copy of the secret. The copy lives on the Go heap, i.e. callers should keep it short-lived.
+func (b *SecureBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.memory == nil {
+		return ""
+	}
+	return string(b.memory.data)
+}
+
+// Use calls fn with the secret as a string that refers to the secure memory itself, i.e. no copy is made on the Go heap.
+// The string is only valid while fn runs and must not be retained. A concurrent Destroy releases the memory after fn returned.
+// If the buffer is nil or was destroyed, fn is not called and DestroyedBufferErr is returned.
+func (b *SecureBuffer) Use(fn func(secret string)) error {
+	if b == nil {
+		return DestroyedBufferErr
+	}
+
+	b.mutex.Lock()
+	memory := b.memory
+	if memory == nil {
+		b.mutex.Unlock()
+		return DestroyedBufferErr
+	}
+	if len(memory.data) == 0 {
+		b.mutex.Unlock()
+		fn("")
+		return nil
+	}
+	b.views++
+	b.mutex.Unlock()
+
+	defer func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.views--
+		if b.views == 0 && b.memory != memory {
+			memory.free()
+		}
+	}()
+	fn(unsafe.String(unsafe.SliceData(memory.data), len(memory.data)))
+	return nil
+}
+
+// Bytes returns a copy of the secret. The caller should wipe the copy after use.
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.memory == nil {
+		return nil
+	}
+	return append([]byte(nil), b.memory.data...)
+}
+
+// Len returns the length of the secret.
+func (b *SecureBuffer) Len() int {
+	if b == nil {
+		return 0
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.memory == nil {
+		return 0
+	}
+	return len(b.memory.data)
+}
+
+// Locked reports whether the secret is held in memory that is locked into RAM.
+func (b *SecureBuffer) Locked() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.memory != nil && b.memory.locked
+}
+
+// Destroy wipes and releases the secret. It is safe to call Destroy multiple times.
+func (b *SecureBuffer) Destroy() {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.memory == nil {
+		return
+	}
+	if b.views == 0 {
+		b.memory.free()
+	}
+	b.memory = nil
+}
+
+// readAt copies len(dst) bytes of the secret at offset into dst.
+func (b *SecureBuffer) readAt(offset int, dst []byte) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.memory != nil {
+		copy(dst, b.memory.data[offset:])
+	}
+}
+
+// writeAt copies data into the secret at offset. The memory is only writable during the copy.
+// It must not be called while Use is running.
+func (b *SecureBuffer) writeAt(offset int, data []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.memory == nil {
+		return
+	}
+	err := b.memory.protect(false)
+	if err != nil {
+		log.Warn("cannot unprotect secure memory", "error", err)
+		return
+	}
+	copy(b.memory.data[offset:], data)
+	err = b.memory.protect(true)
+	if err != nil {
+		log.Warn("cannot protect secure memory", "error", err)
+	}
+}
+
+// free wipes and releases the memory. It is safe to call free multiple times.
+func (m *secureMemory) free() {
+	if m.freed {
+		return
+	}
+	m.freed = true
+
+	if m.heap {
+		clear(m.inner)
+		return
+	}
+
+	err := m.protect(false)
+	if err != nil {
+		log.Warn("cannot unprotect secure memory", "error", err)
+		return
+	}
+	clear(m.inner)
+
+	err = releaseSecureMemory(m)
+	if err != nil {
+		log.Warn("cannot release secure memory", "error", err)
+	}
+}
+
+// protect makes the secret read-only or writable again.
+func (m *secureMemory) protect(readOnly bool) error {
+	if m.heap {
+		return nil
+	}
+	return protectSecureMemory(m, readOnly)
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package password
+
+// allocSecureMemory returns ordinary Go memory, since secure memory is not supported on this platform.
+func allocSecureMemory(size int) (*secureMemory, error) {
+	return newHeapMemory(size), nil
+}
+
+// protectSecureMemory does nothing, since secure memory is not supported on this platform.
+func protectSecureMemory(_ *secureMemory, _ bool) error {
+	return nil
+}
+
+// releaseSecureMemory does nothing, since secure memory is not supported on this platform.
+func releaseSecureMemory(_ *secureMemory) error {
+	return nil
+}
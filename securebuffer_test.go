@@ -0,0 +1,113 @@
+package password
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func TestSecureBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+	}{
+		{"empty", []byte{}},
+		{"short", []byte("123456")},
+		{"page", bytes.Repeat([]byte{0xaa}, 4096)},
+		{"multiple pages", bytes.Repeat([]byte{0x55}, 10000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewSecureBuffer(tt.secret)
+			if got := b.String(); got != string(tt.secret) {
+				t.Errorf("String() = %v, want %v", len(got), len(tt.secret))
+			}
+			if got := b.Bytes(); !bytes.Equal(got, tt.secret) {
+				t.Errorf("Bytes() = %v, want %v", len(got), len(tt.secret))
+			}
+			if got := b.Len(); got != len(tt.secret) {
+				t.Errorf("Len() = %v, want %v", got, len(tt.secret))
+			}
+			if !b.memory.heap && !bytes.Equal(b.memory.inner[len(b.memory.inner)-len(tt.secret):], tt.secret) {
+				t.Errorf("secret is not aligned to the trailing guard page")
+			}
+
+			// the buffer holds a copy
+			if len(tt.secret) > 0 {
+				original := tt.secret[0]
+				tt.secret[0] ^= 0xff
+				if b.Bytes()[0] != original {
+					t.Errorf("NewSecureBuffer() did not copy the secret")
+				}
+				tt.secret[0] = original
+			}
+
+			memory := b.memory
+			b.Destroy()
+			b.Destroy()
+			if !memory.freed || b.String() != "" || b.Bytes() != nil || b.Len() != 0 || b.Locked() {
+				t.Errorf("Destroy() did not wipe the buffer")
+			}
+		})
+	}
+
+	// nil buffers hold the empty secret
+	var b *SecureBuffer
+	b.Destroy()
+	if b.String() != "" || b.Bytes() != nil || b.Len() != 0 || b.Locked() {
+		t.Errorf("nil buffer is not empty")
+	}
+}
+
+func TestSecureBuffer_Use(t *testing.T) {
+	b := NewSecureBuffer([]byte("123456"))
+	memory := b.memory
+	err := b.Use(func(secret string) {
+		if secret != "123456" {
+			t.Errorf("Use() = %v, want 123456", secret)
+		}
+		if unsafe.StringData(secret) != unsafe.SliceData(memory.data) {
+			t.Errorf("Use() copied the secret")
+		}
+
+		// destroyed memory stays readable until the view ends
+		b.Destroy()
+		if memory.freed || secret != "123456" {
+			t.Errorf("Destroy() released memory of a running view")
+		}
+	})
+	if err != nil {
+		t.Errorf("Use() error = %v", err)
+	}
+	if !memory.freed {
+		t.Errorf("Use() did not release destroyed memory")
+	}
+
+	// destroyed and nil buffers fail closed
+	var nilBuffer *SecureBuffer
+	for _, destroyed := range []*SecureBuffer{b, nilBuffer} {
+		err := destroyed.Use(func(secret string) {
+			t.Errorf("Use() of a destroyed buffer called fn with %v", secret)
+		})
+		if !errors.Is(err, DestroyedBufferErr) {
+			t.Errorf("Use() error = %v, want %v", err, DestroyedBufferErr)
+		}
+	}
+
+	// empty secrets are not destroyed
+	called := false
+	err = NewSecureBuffer(nil).Use(func(secret string) { called = secret == "" })
+	if err != nil || !called {
+		t.Errorf("Use() of an empty buffer = %v, %v", called, err)
+	}
+
+	// heap memory is wiped
+	heap := &SecureBuffer{memory: newHeapMemory(6)}
+	copy(heap.memory.data, "123456")
+	data := heap.memory.data
+	heap.Destroy()
+	if !bytes.Equal(data, make([]byte, 6)) {
+		t.Errorf("Destroy() did not wipe heap memory")
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package password
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// allocSecureMemory maps size bytes between two guard pages and locks them into RAM.
+func allocSecureMemory(size int) (*secureMemory, error) {
+	pageSize := os.Getpagesize()
+	innerLength := max((size+pageSize-1)/pageSize, 1) * pageSize
+	region, err := unix.Mmap(-1, 0, innerLength+2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &secureMemory{region: region, inner: region[pageSize : pageSize+innerLength]}
+	m.data = m.inner[innerLength-size:]
+
+	err = unix.Mprotect(region[:pageSize], unix.PROT_NONE)
+	if err == nil {
+		err = unix.Mprotect(region[pageSize+innerLength:], unix.PROT_NONE)
+	}
+	if err != nil {
+		_ = unix.Munmap(region)
+		return nil, err
+	}
+
+	m.locked = unix.Mlock(m.inner) == nil
+	return m, nil
+}
+
+// protectSecureMemory makes the accessible pages read-only or writable.
+func protectSecureMemory(m *secureMemory, readOnly bool) error {
+	prot := unix.PROT_READ | unix.PROT_WRITE
+	if readOnly {
+		prot = unix.PROT_READ
+	}
+	return unix.Mprotect(m.inner, prot)
+}
+
+// releaseSecureMemory unlocks and unmaps the memory. It must be wiped beforehand.
+func releaseSecureMemory(m *secureMemory) error {
+	if m.locked {
+		err := unix.Munlock(m.inner)
+		if err != nil {
+			return err
+		}
+	}
+	return unix.Munmap(m.region)
+}
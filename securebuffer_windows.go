@@ -0,0 +1,62 @@
+//go:build windows
+
+package password
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+	"unsafe"
+)
+
+// allocSecureMemory allocates size bytes between two guard pages and locks them into RAM.
+func allocSecureMemory(size int) (*secureMemory, error) {
+	pageSize := os.Getpagesize()
+	innerLength := max((size+pageSize-1)/pageSize, 1) * pageSize
+	regionLength := innerLength + 2*pageSize
+	address, err := windows.VirtualAlloc(0, uintptr(regionLength), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, err
+	}
+
+	region := unsafe.Slice(*(**byte)(unsafe.Pointer(&address)), regionLength)
+	m := &secureMemory{region: region, inner: region[pageSize : pageSize+innerLength]}
+	m.data = m.inner[innerLength-size:]
+
+	err = virtualProtect(region[:pageSize], windows.PAGE_NOACCESS)
+	if err == nil {
+		err = virtualProtect(region[pageSize+innerLength:], windows.PAGE_NOACCESS)
+	}
+	if err != nil {
+		_ = windows.VirtualFree(address, 0, windows.MEM_RELEASE)
+		return nil, err
+	}
+
+	m.locked = windows.VirtualLock(uintptr(unsafe.Pointer(&m.inner[0])), uintptr(len(m.inner))) == nil
+	return m, nil
+}
+
+// protectSecureMemory makes the accessible pages read-only or writable.
+func protectSecureMemory(m *secureMemory, readOnly bool) error {
+	var protection uint32 = windows.PAGE_READWRITE
+	if readOnly {
+		protection = windows.PAGE_READONLY
+	}
+	return virtualProtect(m.inner, protection)
+}
+
+// releaseSecureMemory unlocks and frees the memory. It must be wiped beforehand.
+func releaseSecureMemory(m *secureMemory) error {
+	if m.locked {
+		err := windows.VirtualUnlock(uintptr(unsafe.Pointer(&m.inner[0])), uintptr(len(m.inner)))
+		if err != nil {
+			return err
+		}
+	}
+	return windows.VirtualFree(uintptr(unsafe.Pointer(&m.region[0])), 0, windows.MEM_RELEASE)
+}
+
+// virtualProtect changes the protection of the pages of memory.
+func virtualProtect(memory []byte, protection uint32) error {
+	var old uint32
+	return windows.VirtualProtect(uintptr(unsafe.Pointer(&memory[0])), uintptr(len(memory)), protection, &old)
+}
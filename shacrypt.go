@@ -0,0 +1,191 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// shaCryptAlphabet is the base64 alphabet of crypt(3).
+const shaCryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const shaCryptDefaultRounds = 5000
+const shaCryptMinRounds = 1000
+const shaCryptMaxRounds = 999999999
+const shaCryptMaxSaltLength = 16
+
+// shaCrypt256Order is the byte order of the sha256-crypt ($5$) encoding in groups of three bytes.
+var shaCrypt256Order = [][]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	{-1, 31, 30},
+}
+
+// shaCrypt512Order is the byte order of the sha512-crypt ($6$) encoding in groups of three bytes.
+var shaCrypt512Order = [][]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41}, {-1, -1, 63},
+}
+
+// shaCryptHash holds the parameters of a sha-crypt hash.
+type shaCryptHash struct {
+	prefix       string
+	newHash      func() hash.Hash
+	order        [][]int
+	salt         []byte
+	rounds       int
+	customRounds bool
+}
+
+// parseShaCrypt decodes the parameters of a sha256-crypt ($5$) or sha512-crypt ($6$) hash.
+func parseShaCrypt(hashedPassword string) (shaCryptHash, error) {
+	h := shaCryptHash{prefix: "$5$", newHash: sha256.New, order: shaCrypt256Order, rounds: shaCryptDefaultRounds}
+	if strings.HasPrefix(hashedPassword, "$6$") {
+		h.prefix, h.newHash, h.order = "$6$", sha512.New, shaCrypt512Order
+	}
+
+	fields := strings.Split(strings.TrimPrefix(hashedPassword, h.prefix), "$")
+	if len(fields) == 3 {
+		roundsText, found := strings.CutPrefix(fields[0], "rounds=")
+		if !found {
+			return shaCryptHash{}, fmt.Errorf("%w: invalid sha-crypt rounds", CorruptEntryErr)
+		}
+		n, err := strconv.Atoi(roundsText)
+		if err != nil {
+			return shaCryptHash{}, fmt.Errorf("%w: %w", CorruptEntryErr, err)
+		}
+		if n > maxShaCryptRounds {
+			return shaCryptHash{}, fmt.Errorf("%w: invalid sha-crypt rounds", CorruptEntryErr)
+		}
+		h.rounds, h.customRounds = min(max(n, shaCryptMinRounds), shaCryptMaxRounds), true
+		fields = fields[1:]
+	}
+	if len(fields) != 2 || fields[1] == "" {
+		return shaCryptHash{}, fmt.Errorf("%w: invalid sha-crypt hash", CorruptEntryErr)
+	}
+	h.salt = []byte(fields[0])
+
+	return h, nil
+}
+
+// compareShaCrypt compares a sha256-crypt ($5$) or sha512-crypt ($6$) hash against a password.
+// If verify is false, the hash format is only validated.
+func compareShaCrypt(hashedPassword string, password string, verify bool) (bool, error) {
+	h, err := parseShaCrypt(hashedPassword)
+	if err != nil || !verify {
+		return false, err
+	}
+
+	other := shaCrypt(h.newHash, h.order, h.prefix, []byte(password), h.salt, h.rounds, h.customRounds)
+
+	return comparePassword(hashedPassword, other), nil
+}
+
+// shaCrypt returns the crypt(3) string of the SHA-crypt algorithm by Ulrich Drepper.
+// See https://www.akkadia.org/drepper/SHA-crypt.txt
+func shaCrypt(newHash func() hash.Hash, order [][]int, prefix string, password []byte, salt []byte, rounds int, customRounds bool) string {
+	salt = salt[:min(len(salt), shaCryptMaxSaltLength)]
+
+	// digest B
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+	size := len(digestB)
+
+	// digest A
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	i := len(password)
+	for ; i > size; i -= size {
+		a.Write(digestB)
+	}
+	a.Write(digestB[:i])
+	for i = len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	// sequence P
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeatBytes(dp.Sum(nil), len(password))
+
+	// sequence S
+	ds := newHash()
+	for range 16 + int(digestA[0]) {
+		ds.Write(salt)
+	}
+	s := repeatBytes(ds.Sum(nil), len(salt))
+
+	// rounds
+	c := digestA
+	for r := range rounds {
+		h := newHash()
+		if r&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if r%3 != 0 {
+			h.Write(s)
+		}
+		if r%7 != 0 {
+			h.Write(p)
+		}
+		if r&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	// encoding
+	result := new(strings.Builder)
+	result.WriteString(prefix)
+	if customRounds {
+		result.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	result.Write(salt)
+	result.WriteString("$")
+	for _, group := range order {
+		w, n := 0, 1
+		for _, index := range group {
+			w <<= 8
+			if index >= 0 {
+				w |= int(c[index])
+				n++
+			}
+		}
+		for range n {
+			result.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	return result.String()
+}
+
+// repeatBytes returns data repeated up to length bytes.
+func repeatBytes(data []byte, length int) []byte {
+	result := make([]byte, length)
+	for i := 0; i < length; i += len(data) {
+		copy(result[i:], data)
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ShareErr is wrapped if secret shares cannot be created or combined.
+var ShareErr = errors.New("invalid secret shares")
+
+// maxShares is the maximum number of shares, since every share needs a distinct non-zero x coordinate in GF(256).
+const maxShares = 255
+
+// shareHeaderLength is the length of the threshold and x coordinate that precede the share bytes.
+const shareHeaderLength = 2
+
+// recoveryKeyLength is the number of random bytes of a generated recovery key.
+const recoveryKeyLength = 32
+
+// gfMul multiplies two elements of GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1 in constant time.
+func gfMul(a byte, b byte) byte {
+	var result byte
+	for range 8 {
+		result ^= a & -(b & 1)
+		a = (a << 1) ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return result
+}
+
+// gfInv returns the multiplicative inverse a^254 of a non-zero element of GF(256).
+func gfInv(a byte) byte {
+	result := byte(1)
+	for range 7 {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// SplitSecret splits secret with Shamir's secret sharing over GF(256) into the given number of shares.
+// Any threshold shares reconstruct the secret with CombineShares, fewer shares reveal nothing about it.
+// Every share starts with the threshold and its x coordinate, followed by one byte per secret byte.
+func SplitSecret(secret []byte, threshold int, shares int) ([][]byte, error) {
+	if threshold < 2 || threshold > shares || shares > maxShares {
+		return nil, fmt.Errorf("%w: need 2 <= threshold (%v) <= shares (%v) <= %v", ShareErr, threshold, shares, maxShares)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: empty secret", ShareErr)
+	}
+
+	result := make([][]byte, shares)
+	for i := range result {
+		result[i] = make([]byte, shareHeaderLength+len(secret))
+		result[i][0] = byte(threshold)
+		result[i][1] = byte(i + 1)
+	}
+
+	// random polynomial of degree threshold - 1 with the secret byte as constant term
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+	for j, s := range secret {
+		_, err := rand.Read(coefficients[1:])
+		if err != nil {
+			return nil, err
+		}
+		coefficients[0] = s
+
+		for _, share := range result {
+			x := share[1]
+			var y byte
+			for k := threshold - 1; k >= 0; k-- {
+				y = gfMul(y, x) ^ coefficients[k]
+			}
+			share[shareHeaderLength+j] = y
+		}
+	}
+
+	return result, nil
+}
+
+// CombineShares reconstructs a secret of SplitSecret from at least threshold distinct shares.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: no shares", ShareErr)
+	}
+
+	length := len(shares[0])
+	threshold := 0
+	if length > shareHeaderLength {
+		threshold = int(shares[0][0])
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != length || length <= shareHeaderLength {
+			return nil, fmt.Errorf("%w: shares have different or invalid lengths", ShareErr)
+		}
+		if int(share[0]) != threshold || threshold < 2 {
+			return nil, fmt.Errorf("%w: shares have different or invalid thresholds", ShareErr)
+		}
+		if share[1] == 0 || seen[share[1]] {
+			return nil, fmt.Errorf("%w: duplicate or invalid share", ShareErr)
+		}
+		seen[share[1]] = true
+	}
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%w: got %v shares, need %v", ShareErr, len(shares), threshold)
+	}
+	shares = shares[:threshold]
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, length-shareHeaderLength)
+	for i, share := range shares {
+		basis := byte(1)
+		for k, other := range shares {
+			if k != i {
+				basis = gfMul(basis, gfMul(other[1], gfInv(other[1]^share[1])))
+			}
+		}
+		for j := range secret {
+			secret[j] ^= gfMul(basis, share[shareHeaderLength+j])
+		}
+	}
+
+	return secret, nil
+}
+
+// EncodeShare encodes a share of SplitSecret as text for distribution.
+func EncodeShare(share []byte) string {
+	return base64.RawURLEncoding.EncodeToString(share)
+}
+
+// DecodeShare decodes a share of EncodeShare.
+func DecodeShare(encodedShare string) ([]byte, error) {
+	share, err := base64.RawURLEncoding.DecodeString(encodedShare)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ShareErr, err)
+	}
+	return share, nil
+}
+
+// CombineRecoveryShares reconstructs the recovery key of EnableSplitRecovery from at least threshold encoded shares.
+func CombineRecoveryShares(encodedShares []string) (string, error) {
+	shares := make([][]byte, len(encodedShares))
+	for i, encodedShare := range encodedShares {
+		share, err := DecodeShare(encodedShare)
+		if err != nil {
+			return "", err
+		}
+		shares[i] = share
+	}
+
+	key, err := CombineShares(shares)
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+
+	return string(key), nil
+}
+
+// EnableSplitRecovery enables recovery with a random recovery key that is split into the given number of shares.
+// Any threshold of the returned shares reconstruct the recovery key (see CombineRecoveryShares and cmd/recovery),
+// such that no single share holder can recover passwords. The recovery key is never returned.
+func (m *Manager) EnableSplitRecovery(threshold int, shares int) ([]string, error) {
+	random := make([]byte, recoveryKeyLength)
+	defer clear(random)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, base64.RawURLEncoding.EncodedLen(len(random)))
+	defer clear(key)
+	base64.RawURLEncoding.Encode(key, random)
+
+	split, err := SplitSecret(key, threshold, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(split))
+	for i, share := range split {
+		result[i] = EncodeShare(share)
+		clear(share)
+	}
+
+	m.enableRecoveryKey(key)
+	return result, nil
+}
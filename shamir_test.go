@@ -0,0 +1,97 @@
+package password
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_gfInv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Fatalf("gfMul(%v, gfInv(%v)) = %v, want 1", a, a, got)
+		}
+	}
+}
+
+func TestSplitSecret(t *testing.T) {
+	secret := []byte("recovery key")
+	shares, err := SplitSecret(secret, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		shares  [][]byte
+		wantErr error
+	}{
+		{"threshold", [][]byte{shares[0], shares[2], shares[4]}, nil},
+		{"other threshold", [][]byte{shares[3], shares[1], shares[0]}, nil},
+		{"all", shares, nil},
+		{"too few", [][]byte{shares[0], shares[1]}, ShareErr},
+		{"duplicate", [][]byte{shares[0], shares[0], shares[1]}, ShareErr},
+		{"truncated", [][]byte{shares[0], shares[1], shares[2][:4]}, ShareErr},
+		{"none", nil, ShareErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CombineShares(tt.shares)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CombineShares() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, secret) {
+				t.Errorf("CombineShares() = %v, want %v", got, secret)
+			}
+		})
+	}
+
+	for _, args := range [][2]int{{1, 3}, {4, 3}, {2, 256}} {
+		_, err = SplitSecret(secret, args[0], args[1])
+		if !errors.Is(err, ShareErr) {
+			t.Errorf("SplitSecret(%v, %v) error = %v, want %v", args[0], args[1], err, ShareErr)
+		}
+	}
+}
+
+func TestManager_EnableSplitRecovery(t *testing.T) {
+	// init test
+	m := NewManagerWithStorage(NewTemporaryStorage())
+	m.SetHashFunc(sha256Hash)
+	shares, err := m.EnableSplitRecovery(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 3 {
+		t.Fatalf("EnableSplitRecovery() = %v shares, want 3", len(shares))
+	}
+	err = m.Overwrite("foo", "123", "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// any two shares recover the storage key
+	for i := range shares {
+		other := shares[(i+1)%len(shares)]
+		recoveryKey, err := CombineRecoveryShares([]string{shares[i], other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageKey, err := m.Get("foo"+RecoveryIdSuffix, recoveryKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if storageKey != "456" {
+			t.Errorf("storage key = %v, want 456", storageKey)
+		}
+	}
+
+	_, err = CombineRecoveryShares(shares[:1])
+	if !errors.Is(err, ShareErr) {
+		t.Errorf("CombineRecoveryShares() error = %v, want %v", err, ShareErr)
+	}
+	_, err = CombineRecoveryShares([]string{shares[0], "not a share!"})
+	if !errors.Is(err, ShareErr) {
+		t.Errorf("CombineRecoveryShares() error = %v, want %v", err, ShareErr)
+	}
+}
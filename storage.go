@@ -6,9 +6,19 @@ import (
 	"strings"
 )
 
-var unsupportedStorageError = errors.New("unsupported storage backend")
+// NotFoundErr is wrapped by every storage backend and manager method if the requested id does not exist.
+var NotFoundErr = errors.New("id not found")
+
+// CorruptEntryErr is wrapped if stored data cannot be read or decoded.
+var CorruptEntryErr = errors.New("corrupt storage entry")
+
+// UnsupportedStorageErr is returned if an operation is not available for the current storage backend.
+var UnsupportedStorageErr = errors.New("unsupported storage backend")
+
 var invalidStorageTypeErr = errors.New("invalid storage type")
 
+// Storage is the interface for password storage backends.
+// Implementations must wrap NotFoundErr if Retrieve or Delete are called with an unknown id.
 type Storage interface {
 	// Store (create/overwrite) the provided data.
 	Store(id string, data string) error
@@ -35,6 +45,30 @@ type Storage interface {
 	LoadJSON(input string) error
 }
 
+// pathStorage is implemented by storage backends that are located at a storage path, e.g. FileStorage.
+type pathStorage interface {
+	GetStorePath() string
+	SetStorePath(path string)
+	FilePath(id string) string
+}
+
+// folderStorage is implemented by storage backends that can list a folder without listing all ids, e.g. FileStorage.
+type folderStorage interface {
+	ListFolder(folder string) ([]string, error)
+}
+
+// createStorage is implemented by storage backends that can store data only if an id does not exist yet, e.g. FileStorage.
+// The keyring of envelope encryption is created with Create, such that concurrent managers agree on one keyring.
+type createStorage interface {
+	Create(id string, data string) (bool, error)
+}
+
+// diskStorage is implemented by storage backends that can be synchronized with a FileStorage on disk, e.g. TemporaryStorage.
+type diskStorage interface {
+	WriteToDisk(path string) error
+	ReadFromDisk(path string) error
+}
+
 // normalizeSeparator replaces all backward-slash ("\\") with forward-slash ("/") characters
 func normalizeSeparator(s string) string {
 	return strings.ReplaceAll(s, "\\", "/")
@@ -49,75 +83,35 @@ func NormalizeId(id string) string {
 	return pathlib.Clean(id)
 }
 
-// GetStorePath returns the current storage path with system-specific path separators.
+// GetStorePath returns the current storage path of the default manager with system-specific path separators.
 func GetStorePath() (string, error) {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *FileStorage:
-		return m.storageBackend.(*FileStorage).GetStorePath(), nil
-	}
-
-	return "", unsupportedStorageError
+	return GetDefaultManager().GetStorePath()
 }
 
-// SetStorePath accepts a new storage path with system-unspecific or mixed path separators.
+// SetStorePath accepts a new storage path for the default manager with system-unspecific or mixed path separators.
 func SetStorePath(path string) error {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *FileStorage:
-		m.storageBackend.(*FileStorage).SetStorePath(path)
-		return nil
-	}
-
-	return unsupportedStorageError
+	return GetDefaultManager().SetStorePath(path)
 }
 
 // FilePath returns the storage filepath of a given password-id with system-specific path separators.
 // It accepts system-unspecific or mixed id separators, i.e. forward- and backward-slashes are treated as the same character.
 func FilePath(id string) (string, error) {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *FileStorage:
-		return m.storageBackend.(*FileStorage).FilePath(id), nil
-	}
-
-	return "", unsupportedStorageError
+	return GetDefaultManager().FilePath(id)
 }
 
 // SetTemporaryStorage overwrites the current storage backend with a memory based one.
 func SetTemporaryStorage() {
-	GetDefaultManager().storageBackend = NewTemporaryStorage()
+	GetDefaultManager().SetStorage(NewTemporaryStorage())
 }
 
 // DumpJSON serializes the storage backend to a JSON string.
 func DumpJSON() (string, error) {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *FileStorage:
-		return m.storageBackend.(*FileStorage).DumpJSON()
-	case *TemporaryStorage:
-		return m.storageBackend.(*TemporaryStorage).DumpJSON()
-	}
-
-	return "", unsupportedStorageError
+	return GetDefaultManager().DumpJSON()
 }
 
 // LoadJSON deserializes a JSON string into the storage backend.
 func LoadJSON(input string) error {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *FileStorage:
-		return m.storageBackend.(*FileStorage).LoadJSON(input)
-	case *TemporaryStorage:
-		return m.storageBackend.(*TemporaryStorage).LoadJSON(input)
-	}
-
-	return unsupportedStorageError
+	return GetDefaultManager().LoadJSON(input)
 }
 
 // WriteToDisk saves the current storage to files via FileStorage mechanisms.
@@ -125,14 +119,7 @@ func LoadJSON(input string) error {
 // You should stop operations manually before usage or ignore the reported error.
 // Data consistency is guaranteed.
 func WriteToDisk(path string) error {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *TemporaryStorage:
-		return m.storageBackend.(*TemporaryStorage).WriteToDisk(path)
-	}
-
-	return unsupportedStorageError
+	return GetDefaultManager().WriteToDisk(path)
 }
 
 // ReadFromDisk loads a FileStorage backend from disk into the current storage.
@@ -140,12 +127,5 @@ func WriteToDisk(path string) error {
 // You should stop operations manually before usage or ignore the reported error.
 // Data consistency is guaranteed.
 func ReadFromDisk(path string) error {
-	m := GetDefaultManager()
-
-	switch m.storageBackend.(type) {
-	case *TemporaryStorage:
-		return m.storageBackend.(*TemporaryStorage).ReadFromDisk(path)
-	}
-
-	return unsupportedStorageError
+	return GetDefaultManager().ReadFromDisk(path)
 }
@@ -3,13 +3,13 @@ package password
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
 )
 
-var invalidTemporaryStorageIdErr = errors.New("invalid temporary storage id")
+var invalidTemporaryStorageIdErr = fmt.Errorf("%w: invalid temporary storage id", NotFoundErr)
 
 // TemporaryStorage is a memory based storage backend.
 type TemporaryStorage struct {
@@ -34,6 +34,20 @@ func (t *TemporaryStorage) Store(id string, data string) error {
 	return nil
 }
 
+// Create stores data only if id does not exist yet and reports whether it was stored.
+func (t *TemporaryStorage) Create(id string, data string) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, ok := t.registry[id]
+	if ok {
+		return false, nil
+	}
+	t.registry[id] = data
+
+	return true, nil
+}
+
 // Retrieve data from an existing memory location.
 func (t *TemporaryStorage) Retrieve(id string) (string, error) {
 	t.mutex.Lock()
@@ -77,6 +91,24 @@ func (t *TemporaryStorage) List() ([]string, error) {
 	return list, nil
 }
 
+// ListFolder lists all stored ids inside a folder, e.g. "myid.history/1" for "myid.history".
+func (t *TemporaryStorage) ListFolder(folder string) ([]string, error) {
+	prefix := NormalizeId(folder) + "/"
+	list := make([]string, 0)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for id := range t.registry {
+		if strings.HasPrefix(id, prefix) {
+			list = append(list, id)
+		}
+	}
+
+	sort.Strings(list)
+	return list, nil
+}
+
 // Delete an existing password.
 func (t *TemporaryStorage) Delete(id string) error {
 	t.mutex.Lock()